@@ -2,22 +2,98 @@ package aesgcm
 
 // See https://nvlpubs.nist.gov/nistpubs/FIPS/NIST.FIPS.197.pdf
 
+// The cipher is bitsliced so that nothing is ever looked up in a table by secret data (which would leak
+// through the cache). Plane b of a bitslice holds bit b of every state byte: bit 16*k+i is byte i of
+// block k, where i = 4*col+row is the FIPS 197 input order, so up to four blocks run side by side.
+
 import (
+	"crypto/cipher"
 	"encoding/binary"
 )
 
-func (aesgcm *aesgcm) expandAesKey(key []byte) *aesgcm {
+type bitslice [8]uint64
+
+// Key material for one AES key, written once by expandAesKey and read-only afterwards
+type aesCipher struct {
+	expandedAesKey   [60]uint32
+	roundKeys        [15]bitslice // expandedAesKey in bitsliced form, repeated for four parallel blocks
+	roundKeyBytes    [240]byte    // expandedAesKey in byte order, for AES-NI
+	decRoundKeyBytes [240]byte    // Equivalent inverse cipher round keys (FIPS 197 section 5.3.5), for AES-NI
+	asm              bool         // Use the assembly backend, see useAsm
+	nr               int          // Number of rounds
+	destroyed        bool         // Key material wiped by destroy; checked by every mode before use
+}
+
+// aesBlock is the cipher.Block view of an aesCipher
+type aesBlock struct {
+	aesCipher
+}
+
+// NewCipher returns an AES block cipher with both the cipher and the inverse cipher, for use with the modes
+// of crypto/cipher. The key must be 16, 24 or 32 bytes, otherwise ErrKeySize is returned.
+func NewCipher(key []byte) (cipher.Block, error) {
+	if (len(key) != 16) && (len(key) != 24) && (len(key) != 32) {
+		return nil, ErrKeySize
+	}
+	var block = new(aesBlock)
+	block.asm = useAsm
+	block.expandAesKey(key)
+	return block, nil
+}
+
+// BlockSize returns the AES block size of 16 bytes.
+func (block *aesBlock) BlockSize() int {
+	return 16
+}
+
+// Destroy wipes the key schedule; the block implements Destroyer, and Encrypt and Decrypt then panic with
+// ErrDestroyed
+func (block *aesBlock) Destroy() {
+	block.aesCipher.destroy()
+}
+
+// Encrypt encrypts the first 16-byte block of src into dst.
+func (block *aesBlock) Encrypt(dst, src []byte) {
+	checkBlock(dst, src)
+	if block.destroyed {
+		panic(ErrDestroyed)
+	}
+	block.encryptBlocks(dst[:16], src[:16])
+}
+
+// Decrypt decrypts the first 16-byte block of src into dst.
+func (block *aesBlock) Decrypt(dst, src []byte) {
+	checkBlock(dst, src)
+	if block.destroyed {
+		panic(ErrDestroyed)
+	}
+	block.decryptBlocks(dst[:16], src[:16])
+}
+
+func checkBlock(dst, src []byte) {
+	if len(src) < 16 {
+		panic("Input is not a full block")
+	}
+	if len(dst) < 16 {
+		panic("Output is not a full block")
+	}
+	if inexactOverlap(dst[:16], src[:16]) {
+		panic("Invalid buffer overlap of dst and src")
+	}
+}
+
+func (aes *aesCipher) expandAesKey(key []byte) *aesCipher {
 	var nk = len(key) / 4
 	rcon := [11]uint32{0, 0x01000000, 0x02000000, 0x04000000, 0x08000000, 0x10000000, 0x20000000, 0x40000000, 0x80000000, 0x1b000000, 0x36000000}
-	aesgcm.nr = nk + 6
+	aes.nr = nk + 6
 
 	for index := 0; index < nk; index++ {
-		aesgcm.expandedAesKey[index] = uint32(key[index*4])<<24 | uint32(key[index*4+1])<<16 |
+		aes.expandedAesKey[index] = uint32(key[index*4])<<24 | uint32(key[index*4+1])<<16 |
 			uint32(key[index*4+2])<<8 | uint32(key[index*4+3])
 	}
 
-	for index := nk; index < (aesgcm.nr+1)*4; index++ {
-		temp := aesgcm.expandedAesKey[index-1]
+	for index := nk; index < (aes.nr+1)*4; index++ {
+		temp := aes.expandedAesKey[index-1]
 		if index%nk == 0 {
 			rw := rotWord(temp)
 			sw := subWord(rw)
@@ -26,9 +102,39 @@ func (aesgcm *aesgcm) expandAesKey(key []byte) *aesgcm {
 		} else if nk > 6 && index%nk == 4 {
 			temp = subWord(temp)
 		}
-		aesgcm.expandedAesKey[index] = aesgcm.expandedAesKey[index-nk] ^ temp
+		aes.expandedAesKey[index] = aes.expandedAesKey[index-nk] ^ temp
+	}
+
+	var roundKey [16]byte // On the stack, and wiped, so no copy of the key outlives the schedule
+	for round := 0; round < aes.nr+1; round++ {
+		for col := 0; col < 4; col++ {
+			binary.BigEndian.PutUint32(roundKey[col*4:], aes.expandedAesKey[round*4+col])
+		}
+		copy(aes.roundKeyBytes[round*16:], roundKey[:])
+		for block := 0; block < 4; block++ { // Same round key for all four blocks
+			aes.roundKeys[round].load(roundKey[:], block)
+		}
+		if round == 0 || round == aes.nr {
+			copy(aes.decRoundKeyBytes[(aes.nr-round)*16:], roundKey[:])
+		} else {
+			var q bitslice
+			q.load(roundKey[:], 0)
+			q.invMixColumns()
+			q.store(aes.decRoundKeyBytes[(aes.nr-round)*16:], 0)
+		}
 	}
-	return aesgcm
+	zero(roundKey[:])
+	return aes
+}
+
+// destroy wipes the key schedule. The round count stays, so a missed destroyed check still runs a full
+// (all-zero key) cipher rather than an identity.
+func (aes *aesCipher) destroy() {
+	aes.expandedAesKey = [60]uint32{}
+	aes.roundKeys = [15]bitslice{}
+	zero(aes.roundKeyBytes[:])
+	zero(aes.decRoundKeyBytes[:])
+	aes.destroyed = true
 }
 
 func rotWord(word uint32) uint32 { // expandAesKey expansion
@@ -42,114 +148,299 @@ func rotWord(word uint32) uint32 { // expandAesKey expansion
 }
 
 func subWord(word uint32) uint32 { // expandAesKey expansion
-	var bytes = make([]byte, 4)
-	binary.BigEndian.PutUint32(bytes, word) // byte0->MSB
-	for i := 0; i < 4; i++ {
-		var row = bytes[i] >> 4
-		var col = bytes[i] & 0x0f
-		bytes[i] = sBox[row][col]
-	}
-	var x = binary.BigEndian.Uint32(bytes)
+	var q bitslice
+	for bit := uint(0); bit < 8; bit++ {
+		for i := uint(0); i < 4; i++ {
+			q[bit] |= uint64(word>>(8*i+bit)&1) << i
+		}
+	}
+	q.subBytes()
+	var x uint32
+	for bit := uint(0); bit < 8; bit++ {
+		for i := uint(0); i < 4; i++ {
+			x |= uint32(q[bit]>>i&1) << (8*i + bit)
+		}
+	}
 	return x
 }
 
-func (aesgcm *aesgcm) encrypt(message []byte) []byte {
-	if len(message) == 64 {
-		panic("Encryption currently only works for a single block")
-	}
-	var cipherText = make([]byte, 16)
+func (aes *aesCipher) encrypt(block [16]byte) [16]byte { // By value, so nothing is left on the heap
+	aes.encryptBlocks(block[:], block[:])
+	return block
+}
 
-	for row := 0; row < 4; row++ {
-		for col := 0; col < 4; col++ {
-			aesgcm.state[row][col] = message[col*4+row]
-		}
+// encryptBlocksGeneric encrypts up to four consecutive 16-byte blocks of src into dst in one bitsliced pass
+func (aes *aesCipher) encryptBlocksGeneric(dst, src []byte) {
+	var q bitslice
+	for block := 0; block < len(src)/16; block++ {
+		q.load(src[block*16:block*16+16], block)
 	}
-	aesgcm.addRoundKey(0)
-	for round := 1; round < aesgcm.nr+1; round++ {
-		aesgcm.subBytes()
-		aesgcm.shiftRows()
-		if round != aesgcm.nr {
-			aesgcm.mixColumns()
+	q.addRoundKey(&aes.roundKeys[0])
+	for round := 1; round < aes.nr+1; round++ {
+		q.subBytes()
+		q.shiftRows()
+		if round != aes.nr {
+			q.mixColumns()
 		}
-		aesgcm.addRoundKey(round)
+		q.addRoundKey(&aes.roundKeys[round])
 	}
+	for block := 0; block < len(src)/16; block++ {
+		q.store(dst[block*16:block*16+16], block)
+	}
+}
 
-	for row := 0; row < 4; row++ {
-		for col := 0; col < 4; col++ {
-			cipherText[col*4+row] = aesgcm.state[row][col]
+// decryptBlocksGeneric is the inverse cipher (FIPS 197 section 5.3) of encryptBlocksGeneric
+func (aes *aesCipher) decryptBlocksGeneric(dst, src []byte) {
+	var q bitslice
+	for block := 0; block < len(src)/16; block++ {
+		q.load(src[block*16:block*16+16], block)
+	}
+	q.addRoundKey(&aes.roundKeys[aes.nr])
+	for round := aes.nr - 1; round >= 0; round-- {
+		q.invShiftRows()
+		q.invSubBytes()
+		q.addRoundKey(&aes.roundKeys[round])
+		if round != 0 {
+			q.invMixColumns()
 		}
 	}
-	return cipherText
+	for block := 0; block < len(src)/16; block++ {
+		q.store(dst[block*16:block*16+16], block)
+	}
 }
 
-func (aesgcm *aesgcm) addRoundKey(round int) {
-	for col := 0; col < 4; col++ {
-		var colWord uint32
-		colWord = uint32(aesgcm.state[0][col])<<24 + uint32(aesgcm.state[1][col])<<16 + uint32(aesgcm.state[2][col])<<8 + uint32(aesgcm.state[3][col])
-		colWord = colWord ^ aesgcm.expandedAesKey[(round*4)+col]
-		aesgcm.state[0][col] = byte(colWord >> 24)
-		aesgcm.state[1][col] = byte(colWord >> 16)
-		aesgcm.state[2][col] = byte(colWord >> 8)
-		aesgcm.state[3][col] = byte(colWord)
+func (q *bitslice) load(block []byte, index int) {
+	for i := 0; i < 16; i++ {
+		for bit := uint(0); bit < 8; bit++ {
+			q[bit] |= uint64(block[i]>>bit&1) << uint(16*index+i)
+		}
 	}
 }
 
-func (aesgcm *aesgcm) subBytes() { // Round cipher
-	for row := 0; row < 4; row++ {
-		for col := 0; col < 4; col++ {
-			var itemRow = aesgcm.state[row][col] >> 4
-			var itemCol = aesgcm.state[row][col] & 0x0f
-			aesgcm.state[row][col] = sBox[itemRow][itemCol]
+func (q *bitslice) store(block []byte, index int) {
+	for i := 0; i < 16; i++ {
+		var x byte
+		for bit := uint(0); bit < 8; bit++ {
+			x |= byte(q[bit]>>uint(16*index+i)&1) << bit
 		}
+		block[i] = x
+	}
+}
+
+func (q *bitslice) addRoundKey(roundKey *bitslice) {
+	for bit := 0; bit < 8; bit++ {
+		q[bit] ^= roundKey[bit]
 	}
 }
 
-func (aesgcm *aesgcm) shiftRows() {
-	var newState = [4][4]byte{}
-	copy(newState[0][0:4], aesgcm.state[0][0:4]) // Row 0 is unchanged
+// Boyar-Peralta S-box circuit (https://eprint.iacr.org/2011/332.pdf), 113 gates and no lookups
+func (q *bitslice) subBytes() { // Round cipher
+	x0, x1, x2, x3, x4, x5, x6, x7 := q[7], q[6], q[5], q[4], q[3], q[2], q[1], q[0]
 
-	copy(newState[1][0:3], aesgcm.state[1][1:4]) // Row 1
-	copy(newState[1][3:4], aesgcm.state[1][0:1]) // Row 1
+	// Top linear transformation
+	y14 := x3 ^ x5
+	y13 := x0 ^ x6
+	y9 := x0 ^ x3
+	y8 := x0 ^ x5
+	t0 := x1 ^ x2
+	y1 := t0 ^ x7
+	y4 := y1 ^ x3
+	y12 := y13 ^ y14
+	y2 := y1 ^ x0
+	y5 := y1 ^ x6
+	y3 := y5 ^ y8
+	t1 := x4 ^ y12
+	y15 := t1 ^ x5
+	y20 := t1 ^ x1
+	y6 := y15 ^ x7
+	y10 := y15 ^ t0
+	y11 := y20 ^ y9
+	y7 := x7 ^ y11
+	y17 := y10 ^ y11
+	y19 := y10 ^ y8
+	y16 := t0 ^ y11
+	y21 := y13 ^ y16
+	y18 := x0 ^ y16
 
-	copy(newState[2][0:2], aesgcm.state[2][2:4]) // Row 2
-	copy(newState[2][2:4], aesgcm.state[2][0:2]) // Row 2
+	// Non-linear section
+	t2 := y12 & y15
+	t3 := y3 & y6
+	t4 := t3 ^ t2
+	t5 := y4 & x7
+	t6 := t5 ^ t2
+	t7 := y13 & y16
+	t8 := y5 & y1
+	t9 := t8 ^ t7
+	t10 := y2 & y7
+	t11 := t10 ^ t7
+	t12 := y9 & y11
+	t13 := y14 & y17
+	t14 := t13 ^ t12
+	t15 := y8 & y10
+	t16 := t15 ^ t12
+	t17 := t4 ^ t14
+	t18 := t6 ^ t16
+	t19 := t9 ^ t14
+	t20 := t11 ^ t16
+	t21 := t17 ^ y20
+	t22 := t18 ^ y19
+	t23 := t19 ^ y21
+	t24 := t20 ^ y18
 
-	copy(newState[3][0:1], aesgcm.state[3][3:4]) // Row 3
-	copy(newState[3][1:4], aesgcm.state[3][0:3]) // Row 3
+	t25 := t21 ^ t22
+	t26 := t21 & t23
+	t27 := t24 ^ t26
+	t28 := t25 & t27
+	t29 := t28 ^ t22
+	t30 := t23 ^ t24
+	t31 := t22 ^ t26
+	t32 := t31 & t30
+	t33 := t32 ^ t24
+	t34 := t23 ^ t33
+	t35 := t27 ^ t33
+	t36 := t24 & t35
+	t37 := t36 ^ t34
+	t38 := t27 ^ t36
+	t39 := t29 & t38
+	t40 := t25 ^ t39
 
-	aesgcm.state = newState
+	t41 := t40 ^ t37
+	t42 := t29 ^ t33
+	t43 := t29 ^ t40
+	t44 := t33 ^ t37
+	t45 := t42 ^ t41
+	z0 := t44 & y15
+	z1 := t37 & y6
+	z2 := t33 & x7
+	z3 := t43 & y16
+	z4 := t40 & y1
+	z5 := t29 & y7
+	z6 := t42 & y11
+	z7 := t45 & y17
+	z8 := t41 & y10
+	z9 := t44 & y12
+	z10 := t37 & y3
+	z11 := t33 & y4
+	z12 := t43 & y13
+	z13 := t40 & y5
+	z14 := t29 & y2
+	z15 := t42 & y9
+	z16 := t45 & y14
+	z17 := t41 & y8
+
+	// Bottom linear transformation
+	t46 := z15 ^ z16
+	t47 := z10 ^ z11
+	t48 := z5 ^ z13
+	t49 := z9 ^ z10
+	t50 := z2 ^ z12
+	t51 := z2 ^ z5
+	t52 := z7 ^ z8
+	t53 := z0 ^ z3
+	t54 := z6 ^ z7
+	t55 := z16 ^ z17
+	t56 := z12 ^ t48
+	t57 := t50 ^ t53
+	t58 := z4 ^ t46
+	t59 := z3 ^ t54
+	t60 := t46 ^ t57
+	t61 := z14 ^ t57
+	t62 := t52 ^ t58
+	t63 := t49 ^ t58
+	t64 := z4 ^ t59
+	t65 := t61 ^ t62
+	t66 := z1 ^ t63
+	s0 := t59 ^ t63
+	s6 := t56 ^ ^t62
+	s7 := t48 ^ ^t60
+	t67 := t64 ^ t65
+	s3 := t53 ^ t66
+	s4 := t51 ^ t66
+	s5 := t47 ^ t65
+	s1 := t64 ^ ^s3
+	s2 := t55 ^ ^t67
+
+	q[7], q[6], q[5], q[4], q[3], q[2], q[1], q[0] = s0, s1, s2, s3, s4, s5, s6, s7
+}
+
+// Row r sits at bits 4*col+r of each 16-bit block, so shifting a row left by r columns is a rotate of
+// those bits right by 4*r within the block
+func (q *bitslice) shiftRows() {
+	for bit := 0; bit < 8; bit++ {
+		x := q[bit]
+		q[bit] = x&0x1111111111111111 | // Row 0 is unchanged
+			(x>>4)&0x0222022202220222 | (x<<12)&0x2000200020002000 | // Row 1
+			(x>>8)&0x0044004400440044 | (x<<8)&0x4400440044004400 | // Row 2
+			(x>>12)&0x0008000800080008 | (x<<4)&0x8880888088808880 // Row 3
+	}
 }
 
-func (aesgcm *aesgcm) mixColumns() {
-	var newState = [4][4]byte{}
+// rotRows moves row r+1 of each column into row r
+func rotRows(x uint64) uint64 {
+	return (x>>1)&0x7777777777777777 | (x<<3)&0x8888888888888888
+}
 
-	for col := 0; col < 4; col++ {
-		newState[0][col] = mulMod(0x02, aesgcm.state[0][col]) ^ mulMod(0x03, aesgcm.state[1][col]) ^ aesgcm.state[2][col] ^ aesgcm.state[3][col]
-		newState[1][col] = aesgcm.state[0][col] ^ mulMod(0x02, aesgcm.state[1][col]) ^ mulMod(0x03, aesgcm.state[2][col]) ^ aesgcm.state[3][col]
-		newState[2][col] = aesgcm.state[0][col] ^ aesgcm.state[1][col] ^ mulMod(0x02, aesgcm.state[2][col]) ^ mulMod(0x03, aesgcm.state[3][col])
-		newState[3][col] = mulMod(0x03, aesgcm.state[0][col]) ^ aesgcm.state[1][col] ^ aesgcm.state[2][col] ^ mulMod(0x02, aesgcm.state[3][col])
+// b'[r] = {02}*b[r] ^ {03}*b[r+1] ^ b[r+2] ^ b[r+3] = {02}*(b[r]^b[r+1]) ^ b[r+1] ^ b[r+2] ^ b[r+3]
+func (q *bitslice) mixColumns() {
+	var t, rest bitslice
+	for bit := 0; bit < 8; bit++ {
+		r1 := rotRows(q[bit])
+		r2 := rotRows(r1)
+		t[bit] = q[bit] ^ r1
+		rest[bit] = r1 ^ r2 ^ rotRows(r2)
+	}
+	t.xtime()
+	for bit := 0; bit < 8; bit++ {
+		q[bit] = t[bit] ^ rest[bit]
 	}
-	aesgcm.state = newState
 }
 
-func mulMod(x, y byte) byte {
-	var result uint
-	var runningXt byte
-	runningXt = x
-	for i := uint(0); i < 8; i++ {
-		if y&(1<<i) != 0x00 {
-			result = result ^ uint(runningXt)
-		}
-		runningXt = xtime(runningXt)
+// Multiply every byte by {02} modulo x^8 + x^4 + x^3 + x + 1
+func (q *bitslice) xtime() {
+	hi := q[7]
+	q[7], q[6], q[5], q[4], q[3], q[2], q[1], q[0] = q[6], q[5], q[4], q[3]^hi, q[2]^hi, q[1], q[0]^hi, hi
+}
+
+// InvSubBytes(y) = G(SubBytes(G(y))), where G is the inverse of the affine transformation in SubBytes
+// (FIPS 197 section 5.3.2), so the same circuit provides the multiplicative inverse
+func (q *bitslice) invSubBytes() { // Inverse round cipher
+	q.invAffine()
+	q.subBytes()
+	q.invAffine()
+}
+
+// b'[i] = b[i+2] ^ b[i+5] ^ b[i+7] ^ d[i] with d = {05}
+func (q *bitslice) invAffine() {
+	var b = *q
+	for bit := 0; bit < 8; bit++ {
+		q[bit] = b[(bit+2)%8] ^ b[(bit+5)%8] ^ b[(bit+7)%8]
 	}
-	return byte(result)
+	q[0] = ^q[0]
+	q[2] = ^q[2]
 }
 
-func xtime(x byte) byte {
-	var result uint
-	result = uint(x) << 1
-	if x&(1<<7) != 0 {
-		result = result ^ 0x1b
+// The rotates of shiftRows in the other direction
+func (q *bitslice) invShiftRows() {
+	for bit := 0; bit < 8; bit++ {
+		x := q[bit]
+		q[bit] = x&0x1111111111111111 | // Row 0 is unchanged
+			(x<<4)&0x2220222022202220 | (x>>12)&0x0002000200020002 | // Row 1
+			(x>>8)&0x0044004400440044 | (x<<8)&0x4400440044004400 | // Row 2
+			(x<<12)&0x8000800080008000 | (x>>4)&0x0888088808880888 // Row 3
+	}
+}
+
+// InvMixColumns is MixColumns after adding {04}*(b[r]^b[r+2]) to every row, as
+// {0e,0b,0d,09} = {02,03,01,01} * {05,00,04,00} over the column polynomial
+func (q *bitslice) invMixColumns() {
+	var t bitslice
+	for bit := 0; bit < 8; bit++ {
+		t[bit] = q[bit] ^ rotRows(rotRows(q[bit]))
+	}
+	t.xtime()
+	t.xtime()
+	for bit := 0; bit < 8; bit++ {
+		q[bit] ^= t[bit]
 	}
-	return byte(result)
+	q.mixColumns()
 }
@@ -0,0 +1,208 @@
+package aesgcm
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// AES-CCM per NIST SP 800-38C: CBC-MAC over the formatted nonce, AAD and payload (Appendix A), then CTR
+// mode from counter block 1, with counter block 0 masking the tag
+type aesccm struct {
+	aesCipher
+	nonceSize int
+	tagSize   int
+}
+
+// ErrCCMTagSize is returned by NewAESCCM for a tag length CCM does not define
+var ErrCCMTagSize = errors.New("aesgcm: CCM tag length must be 4, 6, 8, 10, 12, 14 or 16 bytes")
+
+// NewAESCCM returns an AES-CCM cipher with the given nonce length of 7 to 13 bytes and tag length of 4, 6,
+// 8, 10, 12, 14 or 16 bytes, or ErrKeySize, ErrNonceSize or ErrCCMTagSize. The nonce length n fixes the
+// maximum payload at 2^(8*(15-n)) - 1 bytes, e.g. 64 KiB for the 13-byte nonces of IEEE 802.15.4 and BLE.
+func NewAESCCM(key []byte, nonceSize, tagSize int) (cipher.AEAD, error) {
+	if (len(key) != 16) && (len(key) != 24) && (len(key) != 32) {
+		return nil, ErrKeySize
+	}
+	if nonceSize < 7 || nonceSize > 13 {
+		return nil, ErrNonceSize
+	}
+	if tagSize < 4 || tagSize > 16 || tagSize%2 != 0 {
+		return nil, ErrCCMTagSize
+	}
+	var aesccm = new(aesccm)
+	aesccm.nonceSize = nonceSize
+	aesccm.tagSize = tagSize
+	aesccm.asm = useAsm
+	aesccm.expandAesKey(key)
+	return aesccm, nil
+}
+
+func (aesccm *aesccm) NonceSize() int {
+	return aesccm.nonceSize
+}
+
+func (aesccm *aesccm) Overhead() int {
+	return aesccm.tagSize
+}
+
+// Destroy wipes the expanded key, see Destroy of NewAESGCM
+func (aesccm *aesccm) Destroy() {
+	aesccm.aesCipher.destroy()
+}
+
+// Seal encrypts and authenticates plaintext, authenticates additionalData and appends the result to dst.
+// It panics with ErrNonceSize, or ErrMessageTooLarge if plaintext does not fit the nonce's length field, or
+// ErrDestroyed.
+func (aesccm *aesccm) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if aesccm.destroyed {
+		panic(ErrDestroyed)
+	}
+	if len(nonce) != aesccm.nonceSize {
+		panic(ErrNonceSize)
+	}
+	if !aesccm.fits(len(plaintext)) {
+		panic(ErrMessageTooLarge)
+	}
+	ret, out := sliceForAppend(dst, len(plaintext)+aesccm.tagSize)
+	if inexactOverlap(out, plaintext) {
+		panic("Invalid buffer overlap of dst and plaintext")
+	}
+	var tag = aesccm.cbcMac(nonce, plaintext, additionalData)
+	aesccm.ctr(nonce, plaintext, out)
+	copy(out[len(plaintext):], tag[:aesccm.tagSize])
+	return ret
+}
+
+// Open authenticates and decrypts ciphertext, authenticates additionalData and appends the plaintext to
+// dst. It returns ErrNonceSize, ErrMessageTooLarge or ErrAuthFailed, having wiped any decrypted output, or
+// ErrDestroyed.
+func (aesccm *aesccm) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if aesccm.destroyed {
+		return nil, ErrDestroyed
+	}
+	if len(nonce) != aesccm.nonceSize {
+		return nil, ErrNonceSize
+	}
+	if len(ciphertext) < aesccm.tagSize {
+		return nil, ErrAuthFailed
+	}
+	if !aesccm.fits(len(ciphertext) - aesccm.tagSize) {
+		return nil, ErrMessageTooLarge
+	}
+	ret, out := sliceForAppend(dst, len(ciphertext)-aesccm.tagSize)
+	if inexactOverlap(out, ciphertext) {
+		panic("Invalid buffer overlap of dst and ciphertext")
+	}
+	aesccm.ctr(nonce, ciphertext[:len(out)], out) // The MAC covers the plaintext, so decryption comes first
+	var expectedTag = aesccm.cbcMac(nonce, out, additionalData)
+	var tagMatch = subtle.ConstantTimeCompare(ciphertext[len(out):], expectedTag[:aesccm.tagSize])
+	zero(expectedTag[:])
+	if tagMatch != 1 {
+		zero(out)
+		return nil, ErrAuthFailed
+	}
+	return ret, nil
+}
+
+// fits reports whether a payload length can be encoded in the 15 - nonceSize byte length field of B0
+func (aesccm *aesccm) fits(length int) bool {
+	var q = uint(15 - aesccm.nonceSize)
+	return q >= 8 || uint64(length) < uint64(1)<<(8*q)
+}
+
+// counterBlock returns Ctr_i = flags || nonce || [i]q (SP 800-38C Appendix A.3)
+func (aesccm *aesccm) counterBlock(nonce []byte, i uint64) [16]byte {
+	var block [16]byte
+	var q = 15 - aesccm.nonceSize
+	block[0] = byte(q - 1)
+	copy(block[1:], nonce)
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], i)
+	copy(block[16-q:], counter[8-min(q, 8):])
+	return block
+}
+
+// cbcMac returns the tag, CBC-MAC of B0 || encoded AAD || payload masked by the encryption of Ctr_0
+func (aesccm *aesccm) cbcMac(nonce, plaintext, additionalData []byte) [16]byte {
+	var mac = ccmMac{aesCipher: &aesccm.aesCipher}
+	var b0 = aesccm.counterBlock(nonce, uint64(len(plaintext))) // B0 shares the layout of Ctr_i (Appendix A.2.1)
+	b0[0] |= byte(8 * ((aesccm.tagSize - 2) / 2))
+	if len(additionalData) > 0 {
+		b0[0] |= 0x40
+	}
+	mac.write(b0[:])
+
+	if len(additionalData) > 0 { // Length encoding per Appendix A.2.2
+		var encoded [10]byte
+		switch a := uint64(len(additionalData)); {
+		case a < (1<<16)-(1<<8):
+			binary.BigEndian.PutUint16(encoded[:], uint16(a))
+			mac.write(encoded[:2])
+		case a < 1<<32:
+			encoded[0], encoded[1] = 0xff, 0xfe
+			binary.BigEndian.PutUint32(encoded[2:], uint32(a))
+			mac.write(encoded[:6])
+		default:
+			encoded[0], encoded[1] = 0xff, 0xff
+			binary.BigEndian.PutUint64(encoded[2:], a)
+			mac.write(encoded[:10])
+		}
+		mac.write(additionalData)
+		mac.pad()
+	}
+	mac.write(plaintext)
+	mac.pad()
+
+	var s0 = aesccm.counterBlock(nonce, 0)
+	aesccm.encryptBlocks(s0[:], s0[:])
+	for i := range mac.x {
+		mac.x[i] ^= s0[i]
+	}
+	return mac.x
+}
+
+// ctr encrypts message into dst with Ctr_1, Ctr_2, ...
+func (aesccm *aesccm) ctr(nonce, message, dst []byte) {
+	var blocks, keyStream [64]byte
+	for index := 0; index < len(message); index = index + 64 { // Four counter blocks per AES pass
+		var length = min(64, len(message)-index)
+		var count = (length + 15) / 16
+		for block := 0; block < count; block++ {
+			var counter = aesccm.counterBlock(nonce, uint64(1+index/16+block))
+			copy(blocks[block*16:], counter[:])
+		}
+		aesccm.encryptBlocks(keyStream[:count*16], blocks[:count*16])
+		for i := 0; i < length; i++ {
+			dst[i+index] = message[i+index] ^ keyStream[i]
+		}
+	}
+	zero(keyStream[:])
+}
+
+// CBC-MAC chaining value, absorbing input in arbitrary pieces
+type ccmMac struct {
+	aesCipher *aesCipher
+	x         [16]byte
+	buffered  int // Bytes xored into x since its last encryption
+}
+
+func (mac *ccmMac) write(p []byte) {
+	for _, b := range p {
+		mac.x[mac.buffered] ^= b
+		mac.buffered++
+		if mac.buffered == 16 {
+			mac.aesCipher.encryptBlocks(mac.x[:], mac.x[:])
+			mac.buffered = 0
+		}
+	}
+}
+
+// pad completes a partial block with zeros, which leave the chaining value unchanged before encryption
+func (mac *ccmMac) pad() {
+	if mac.buffered > 0 {
+		mac.aesCipher.encryptBlocks(mac.x[:], mac.x[:])
+		mac.buffered = 0
+	}
+}
@@ -0,0 +1,137 @@
+package aesgcm_test
+
+import (
+	"aesgcm"
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+var testCCMEncryptFiles = []string{
+	"./ccmtestvectors/VADT128.rsp", "./ccmtestvectors/VADT192.rsp", "./ccmtestvectors/VADT256.rsp",
+	"./ccmtestvectors/VNT128.rsp", "./ccmtestvectors/VNT192.rsp", "./ccmtestvectors/VNT256.rsp",
+	"./ccmtestvectors/VPT128.rsp", "./ccmtestvectors/VPT192.rsp", "./ccmtestvectors/VPT256.rsp",
+	"./ccmtestvectors/VTT128.rsp", "./ccmtestvectors/VTT192.rsp", "./ccmtestvectors/VTT256.rsp",
+}
+
+var testCCMDecryptFiles = []string{
+	"./ccmtestvectors/DVPT128.rsp", "./ccmtestvectors/DVPT192.rsp", "./ccmtestvectors/DVPT256.rsp",
+}
+
+func Test_ccm_encryption(t *testing.T) {
+	aesgcm.WithEachBackend(t, ccmEncryptionVectors)
+}
+
+// Plen, Nlen, Tlen and Alen are either file-wide or the bracketed section header, depending on the file
+func ccmEncryptionVectors(t *testing.T) {
+	var Alen, Plen, Nlen, Tlen, Count int     // CAVP test fields
+	var Key, Nonce, Adata, Payload, CT []byte // CAVP test fields
+
+	for _, fileName := range testCCMEncryptFiles {
+		var lineNumber int
+
+		fileHandle, err := os.Open(fileName)
+		if err != nil {
+			t.Error(fmt.Sprintf("Unable to open file: %v", fileName))
+			return
+		}
+
+		fileScanner := bufio.NewScanner(fileHandle)
+		for fileScanner.Scan() {
+			line := fileScanner.Text()
+			lineNumber++
+			// Slightly inefficient but effective
+			_, _ = fmt.Sscanf(line, "Alen = %d", &Alen)
+			_, _ = fmt.Sscanf(line, "Plen = %d", &Plen)
+			_, _ = fmt.Sscanf(line, "Nlen = %d", &Nlen)
+			_, _ = fmt.Sscanf(line, "Tlen = %d", &Tlen)
+			_, _ = fmt.Sscanf(line, "[Alen = %d]", &Alen)
+			_, _ = fmt.Sscanf(line, "[Plen = %d]", &Plen)
+			_, _ = fmt.Sscanf(line, "[Nlen = %d]", &Nlen)
+			_, _ = fmt.Sscanf(line, "[Tlen = %d]", &Tlen)
+			_, _ = fmt.Sscanf(line, "Count = %d", &Count)
+			_, _ = fmt.Sscanf(line, "Key = %x", &Key)
+			_, _ = fmt.Sscanf(line, "Nonce = %x", &Nonce)
+			_, _ = fmt.Sscanf(line, "Adata = %x", &Adata)
+			_, _ = fmt.Sscanf(line, "Payload = %x", &Payload)
+			n, _ := fmt.Sscanf(line, "CT = %x", &CT)
+			if n > 0 { // Empty Adata and Payload are written as 00
+				t.Run(fmt.Sprintf("testCCMEncrypt with  %v  line  %d", fileName, lineNumber),
+					testCCMEncrypt(Key, Nonce[0:Nlen], Adata[0:Alen], Payload[0:Plen], CT, Tlen))
+			}
+		}
+		_ = fileHandle.Close()
+	}
+}
+
+func testCCMEncrypt(key, nonce, additionalData, plainText, cipherText []byte, tagLen int) func(*testing.T) {
+	return func(t *testing.T) {
+		aesccm, err := aesgcm.NewAESCCM(key, len(nonce), tagLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := aesccm.Seal(nil, nonce, plainText, additionalData)
+		if !bytes.Equal(cipherText, actual) {
+			t.Error(fmt.Sprintf("\nExpected %x\nGot      %x\n", cipherText, actual)) //
+		}
+	}
+}
+
+func Test_ccm_decryption(t *testing.T) {
+	aesgcm.WithEachBackend(t, ccmDecryptionVectors)
+}
+
+func ccmDecryptionVectors(t *testing.T) {
+	var Alen, Plen, Nlen, Tlen, Count int     // CAVP test fields
+	var Key, Nonce, Adata, Payload, CT []byte // CAVP test fields
+	var Result string
+
+	for _, fileName := range testCCMDecryptFiles {
+		var lineNumber int
+
+		fileHandle, err := os.Open(fileName)
+		if err != nil {
+			t.Error(fmt.Sprintf("Unable to open file: %v", fileName))
+			return
+		}
+
+		fileScanner := bufio.NewScanner(fileHandle)
+		for fileScanner.Scan() {
+			line := fileScanner.Text()
+			lineNumber++
+			// Slightly inefficient but effective
+			_, _ = fmt.Sscanf(line, "[Alen = %d, Plen = %d, Nlen = %d, Tlen = %d]", &Alen, &Plen, &Nlen, &Tlen)
+			_, _ = fmt.Sscanf(line, "Count = %d", &Count)
+			_, _ = fmt.Sscanf(line, "Key = %x", &Key)
+			_, _ = fmt.Sscanf(line, "Nonce = %x", &Nonce)
+			_, _ = fmt.Sscanf(line, "Adata = %x", &Adata)
+			_, _ = fmt.Sscanf(line, "CT = %x", &CT)
+			_, _ = fmt.Sscanf(line, "Result = %s", &Result)
+			n, _ := fmt.Sscanf(line, "Payload = %x", &Payload)
+			if n > 0 || strings.HasPrefix(line, "Result = Fail") { // Only passing tests list a Payload
+				t.Run(fmt.Sprintf("testCCMDecrypt with  %v  line  %d", fileName, lineNumber),
+					testCCMDecrypt(Key, Nonce[0:Nlen], Adata[0:Alen], CT, Payload[0:Plen], Tlen, Result == "Pass"))
+			}
+		}
+		_ = fileHandle.Close()
+	}
+}
+
+func testCCMDecrypt(key, nonce, additionalData, cipherText, plainText []byte, tagLen int, pass bool) func(*testing.T) {
+	return func(t *testing.T) {
+		aesccm, err := aesgcm.NewAESCCM(key, len(nonce), tagLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := aesccm.Open(nil, nonce, cipherText, additionalData)
+		if !pass && err != aesgcm.ErrAuthFailed {
+			t.Error("Expected authentication failure")
+		}
+		if pass && (err != nil || !bytes.Equal(plainText, actual)) {
+			t.Error(fmt.Sprintf("\nExpected %x\nGot      %x  %v\n", plainText, actual, err)) //
+		}
+	}
+}
@@ -1,86 +1,228 @@
 package aesgcm
 
 import (
-	"bytes"
 	"crypto/cipher"
+	"crypto/subtle"
 	"errors"
+	"unsafe"
 )
 
+// Only key material lives here; it is written once by the constructor and read-only afterwards until
+// Destroy, so a single instance may be used by concurrent goroutines. Per-message state stays on the
+// Seal/Open stack.
 type aesgcm struct {
-	expandedAesKey [60]uint32
-	nr             int // Number of rounds
-	state          [4][4]byte
-	h              blockWord
-	hr             blockWord
-	icb            blockWord
-	eky0           blockWord
-	lenAlenC       blockWord
-	runningTag     blockWord
+	aesCipher
+	nonceSize int
+	tagSize   int
+	h         blockWord
+	hr        blockWord
+	hPowers   [8]blockWord // H^1..H^8 for the aggregated reduction of gHashBlocksGeneric
+	hPowersR  [8]blockWord // Ditto, with each half bit-reversed as hr
 }
 
 const (
-	defaultNonceSize int = 12 // 12-byte, 96-bit nonce size (minimum?)
-	defaultTagSize   int = 16 // 16-byte, 128-bit tag size
+	defaultNonceSize      int    = 12             // 12-byte, 96-bit nonce size (recommended)
+	defaultTagSize        int    = 16             // 16-byte, 128-bit tag size
+	maxPlaintextSize      uint64 = (1 << 36) - 32 // 2^39-256 bits, NIST SP 800-38D section 5.2.1.1
+	maxAdditionalDataSize uint64 = (1 << 61) - 1  // 2^64-1 bits, ditto
 )
 
-// NewAESGCM returns an initialized cipher
+// lengthOf is the length checked against the limits above; tests replace it to reach them without huge buffers
+var lengthOf = func(b []byte) uint64 { return uint64(len(b)) }
+
+// useAsm selects the assembly backend for new instances where the CPU supports it (AES-NI and PCLMULQDQ on
+// amd64). Build with -tags purego to always use the constant-time pure Go code.
+var useAsm = supportsAsm
+
+// Errors returned by the constructors and Open, or carried by the panics of the panicking variants and Seal
+var (
+	ErrKeySize         = errors.New("aesgcm: key length must be 128, 192 or 256 bits")
+	ErrNonceSize       = errors.New("aesgcm: incorrect nonce length")
+	ErrTagSize         = errors.New("aesgcm: tag length must be 128, 120, 112, 104, 96, 64 or 32 bits")
+	ErrAuthFailed      = errors.New("aesgcm: message authentication failed")
+	ErrMessageTooLarge = errors.New("aesgcm: message too large for GCM")
+	ErrDestroyed       = errors.New("aesgcm: key has been destroyed")
+)
+
+// Destroyer wipes key material. Every long-lived keyed value of this package implements it: the AEADs, the
+// multi-part contexts, RandomSealer, AESSIV, XTS, and the results of NewCipher, NewGMAC and NewCMAC. Assert
+// a cipher.AEAD, cipher.Block or hash.Hash to it once the key is no longer needed.
+type Destroyer interface {
+	Destroy()
+}
+
+// NewAESGCM returns an initialized cipher; it panics on an invalid key length, see New
 func NewAESGCM(key []byte) cipher.AEAD {
+	return mustAESGCM(newAESGCM(key, defaultNonceSize, defaultTagSize))
+}
+
+// New returns an initialized cipher with the default 12-byte nonce and 16-byte tag, or ErrKeySize
+func New(key []byte) (cipher.AEAD, error) {
+	return newAESGCM(key, defaultNonceSize, defaultTagSize)
+}
+
+// NewWithSizes returns an initialized cipher with the given nonce and tag lengths in bytes, see
+// NewAESGCMWithNonceSize and NewAESGCMWithTagSize. It returns ErrKeySize, ErrNonceSize or ErrTagSize
+// rather than panicking.
+func NewWithSizes(key []byte, nonceSize, tagSize int) (cipher.AEAD, error) {
+	return newAESGCM(key, nonceSize, tagSize)
+}
+
+// NewAESGCMWithNonceSize returns an initialized cipher which accepts nonces of the given length in bytes.
+// Nonces other than 12 bytes are hashed into the pre-counter block J0 (NIST SP 800-38D section 7.1), so
+// this should only be used for compatibility with existing systems.
+func NewAESGCMWithNonceSize(key []byte, size int) cipher.AEAD {
+	return mustAESGCM(newAESGCM(key, size, defaultTagSize))
+}
+
+// NewAESGCMWithTagSize returns an initialized cipher which produces and verifies truncated tags of the
+// given length in bytes. Only the tag lengths approved by NIST SP 800-38D section 5.2.1.2 are accepted:
+// 16, 15, 14, 13, 12, 8 and 4 bytes (128, 120, 112, 104, 96, 64 and 32 bits). See SP 800-38D Appendix C
+// before using 8 or 4 bytes.
+func NewAESGCMWithTagSize(key []byte, tagSize int) cipher.AEAD {
+	return mustAESGCM(newAESGCM(key, defaultNonceSize, tagSize))
+}
+
+func mustAESGCM(aead cipher.AEAD, err error) cipher.AEAD {
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+func newAESGCM(key []byte, nonceSize, tagSize int) (cipher.AEAD, error) {
 	if (len(key) != 16) && (len(key) != 24) && (len(key) != 32) {
-		panic("Aesgcm does not support key lengths other than 128, 192 or 256 bits")
+		return nil, ErrKeySize
+	}
+	if nonceSize <= 0 {
+		return nil, ErrNonceSize
+	}
+	if (tagSize < 12 || tagSize > 16) && tagSize != 8 && tagSize != 4 {
+		return nil, ErrTagSize
 	}
 	var aesgcm = new(aesgcm)
+	aesgcm.nonceSize = nonceSize
+	aesgcm.tagSize = tagSize
+	aesgcm.asm = useAsm
 	aesgcm.expandAesKey(key)
 	aesgcm.initGcmH(key)
-	return aesgcm
+	return aesgcm, nil
 }
 
 // NonceSize returns the size in bytes of the nonce that must be passed to Seal and Open.
 func (aesgcm *aesgcm) NonceSize() int {
-	return defaultNonceSize
+	return aesgcm.nonceSize
 }
 
 // Overhead returns the difference in bytes between the lengths of a plaintext and its ciphertext (e.g. tag size).
 func (aesgcm *aesgcm) Overhead() int {
-	return defaultTagSize
+	return aesgcm.tagSize
 }
 
+// Destroy wipes the expanded key and the hash key H. Later Seal calls panic with ErrDestroyed and Open calls
+// return it. Destroy must not be called concurrently with other methods; memory the Go runtime has already
+// copied, e.g. while growing a stack, is beyond its reach.
+func (aesgcm *aesgcm) Destroy() {
+	aesgcm.aesCipher.destroy()
+	aesgcm.h = blockWord{}
+	aesgcm.hr = blockWord{}
+	aesgcm.hPowers = [8]blockWord{}
+	aesgcm.hPowersR = [8]blockWord{}
+}
+
+// Seal encrypts and authenticates plaintext, authenticates additionalData and appends the result to dst.
+// As cipher.AEAD has no error return, it panics with ErrNonceSize or ErrMessageTooLarge on bad input, the
+// latter beyond the SP 800-38D limits, where the 32-bit block counter would wrap and repeat the keystream,
+// or with ErrDestroyed after Destroy.
 func (aesgcm *aesgcm) Seal(dst []byte, nonce []byte, plaintext, additionalData []byte) []byte {
-	if len(nonce) != defaultNonceSize {
-		panic("Nonce must be 12 bytes")
-	}
-	dst = growAsNeeded(dst, len(plaintext)+defaultTagSize)
-	aesgcm.initGcmY0(len(additionalData), len(plaintext), nonce)
-	aesgcm.runningTag = aesgcm.gHash(additionalData, blockWord{0, 0})
-	aesgcm.cipherBlocks(plaintext, dst)
-	aesgcm.runningTag = aesgcm.gHash(dst[:len(plaintext)], aesgcm.runningTag)
-	aesgcm.runningTag = aesgcm.gMul(bwXor(aesgcm.runningTag, aesgcm.lenAlenC)) //&xx1)
-	aesgcm.runningTag = bwXor(aesgcm.runningTag, aesgcm.eky0)
-	copy(dst[len(plaintext):], bWord2Bytes(aesgcm.runningTag))
-	return dst
-}
-
-func (aesgcm aesgcm) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
-	if len(nonce) != defaultNonceSize {
-		panic("Nonce must be 12 bytes")
-	}
-	dst = growAsNeeded(dst, len(ciphertext)-defaultTagSize)
-	aesgcm.initGcmY0(len(additionalData), len(ciphertext)-defaultTagSize, nonce)
-	aesgcm.runningTag = aesgcm.gHash(additionalData, blockWord{0, 0})
-	aesgcm.runningTag = aesgcm.gHash(ciphertext[:len(ciphertext)-defaultTagSize], aesgcm.runningTag)
-	aesgcm.runningTag = aesgcm.gMul(bwXor(aesgcm.runningTag, aesgcm.lenAlenC))
-	aesgcm.runningTag = bwXor(aesgcm.runningTag, aesgcm.eky0)
-	if !bytes.Equal(ciphertext[len(ciphertext)-defaultTagSize:], bWord2Bytes(aesgcm.runningTag)) {
-		dst = nil
-		return nil, errors.New("cipher: message authentication failed")
-	}
-	aesgcm.cipherBlocks(ciphertext[:len(ciphertext)-defaultTagSize], dst)
-	return dst, nil
-}
-
-func growAsNeeded(slice []byte, length int) []byte {
-	if cap(slice) >= length {
-		return slice[:length]
+	if aesgcm.destroyed {
+		panic(ErrDestroyed)
+	}
+	if len(nonce) != aesgcm.nonceSize {
+		panic(ErrNonceSize)
+	}
+	if lengthOf(plaintext) > maxPlaintextSize || lengthOf(additionalData) > maxAdditionalDataSize {
+		panic(ErrMessageTooLarge)
+	}
+	ret, out := sliceForAppend(dst, len(plaintext)+aesgcm.tagSize)
+	if inexactOverlap(out, plaintext) {
+		panic("Invalid buffer overlap of dst and plaintext")
+	}
+	var icb, eky0 = aesgcm.initGcmY0(nonce)
+	var lenAlenC = blockWord{uint64(len(additionalData)) * 8, uint64(len(plaintext)) * 8}
+	var runningTag = aesgcm.gHash(additionalData, blockWord{0, 0})
+	aesgcm.cipherBlocks(icb, plaintext, out)
+	runningTag = aesgcm.gHash(out[:len(plaintext)], runningTag)
+	runningTag = aesgcm.gMul(bwXor(runningTag, lenAlenC))
+	runningTag = bwXor(runningTag, eky0)
+	var tag [16]byte
+	putBlockWord(tag[:], runningTag)
+	copy(out[len(plaintext):], tag[:aesgcm.tagSize])
+	return ret
+}
+
+// Open authenticates and decrypts ciphertext, authenticates additionalData and appends the plaintext to
+// dst. Malformed input never panics; it returns ErrNonceSize, ErrMessageTooLarge or ErrAuthFailed, or
+// ErrDestroyed after Destroy.
+func (aesgcm *aesgcm) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if aesgcm.destroyed {
+		return nil, ErrDestroyed
+	}
+	if len(nonce) != aesgcm.nonceSize {
+		return nil, ErrNonceSize
+	}
+	if len(ciphertext) < aesgcm.tagSize {
+		return nil, ErrAuthFailed
+	}
+	if lengthOf(ciphertext)-uint64(aesgcm.tagSize) > maxPlaintextSize || lengthOf(additionalData) > maxAdditionalDataSize {
+		return nil, ErrMessageTooLarge
+	}
+	ret, out := sliceForAppend(dst, len(ciphertext)-aesgcm.tagSize)
+	if inexactOverlap(out, ciphertext) {
+		panic("Invalid buffer overlap of dst and ciphertext")
+	}
+	var icb, eky0 = aesgcm.initGcmY0(nonce)
+	var lenAlenC = blockWord{uint64(len(additionalData)) * 8, uint64(len(ciphertext)-aesgcm.tagSize) * 8}
+	var runningTag = aesgcm.gHash(additionalData, blockWord{0, 0})
+	runningTag = aesgcm.gHash(ciphertext[:len(ciphertext)-aesgcm.tagSize], runningTag)
+	runningTag = aesgcm.gMul(bwXor(runningTag, lenAlenC))
+	runningTag = bwXor(runningTag, eky0)
+	var expectedTag [16]byte
+	putBlockWord(expectedTag[:], runningTag)
+	var tagMatch = subtle.ConstantTimeCompare(ciphertext[len(ciphertext)-aesgcm.tagSize:], expectedTag[:aesgcm.tagSize])
+	zero(expectedTag[:])
+	if tagMatch != 1 {
+		zero(out) // Nothing is decrypted before the check, but never hand back stale bytes either
+		return nil, ErrAuthFailed
+	}
+	aesgcm.cipherBlocks(icb, ciphertext[:len(ciphertext)-aesgcm.tagSize], out)
+	return ret, nil
+}
+
+// sliceForAppend extends in by n bytes, returning the whole slice and the n-byte tail to be written
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
 	} else {
-		return append(slice, make([]byte, length-len(slice))...)
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return head, tail
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// inexactOverlap reports whether x and y share memory at any non-corresponding index; exact overlap
+// (e.g. plaintext[:0] as dst) is fine as every block is read before the same block is written
+func inexactOverlap(x, y []byte) bool {
+	if len(x) == 0 || len(y) == 0 || &x[0] == &y[0] {
+		return false
 	}
+	return uintptr(unsafe.Pointer(&x[0])) <= uintptr(unsafe.Pointer(&y[len(y)-1])) &&
+		uintptr(unsafe.Pointer(&y[0])) <= uintptr(unsafe.Pointer(&x[len(x)-1]))
 }
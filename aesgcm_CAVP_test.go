@@ -28,16 +28,48 @@ func Test_vectorsExist(t *testing.T) {
 		_, err := os.Stat(fileName)
 		problem = problem || os.IsNotExist(err)
 	}
+	for _, fileName := range append(testCCMEncryptFiles, testCCMDecryptFiles...) {
+		_, err := os.Stat(fileName)
+		problem = problem || os.IsNotExist(err)
+	}
+	for _, fileName := range append(testKWEncryptFiles, testKWDecryptFiles...) {
+		_, err := os.Stat(fileName)
+		problem = problem || os.IsNotExist(err)
+	}
+	for _, fileName := range append(testCMACGenFiles, testCMACVerFiles...) {
+		_, err := os.Stat(fileName)
+		problem = problem || os.IsNotExist(err)
+	}
+	for _, fileName := range testXTSFiles {
+		_, err := os.Stat(fileName)
+		problem = problem || os.IsNotExist(err)
+	}
 	if problem {
 		fmt.Println("Test vector file(s) are not present. Please:")
 		fmt.Println(" 1. wget https://csrc.nist.gov/CSRC/media/Projects/Cryptographic-Algorithm-Validation-Program/documents/mac/gcmtestvectors.zip")
 		fmt.Println(" 2. unzip gcmtestvectors.zip -d gcmtestvectors")
 		fmt.Println(" 3. rm gcmtestvectors.zip")
+		fmt.Println(" 4. wget https://csrc.nist.gov/CSRC/media/Projects/Cryptographic-Algorithm-Validation-Program/documents/mac/ccmtestvectors.zip")
+		fmt.Println(" 5. unzip ccmtestvectors.zip -d ccmtestvectors")
+		fmt.Println(" 6. rm ccmtestvectors.zip")
+		fmt.Println(" 7. wget https://csrc.nist.gov/CSRC/media/Projects/Cryptographic-Algorithm-Validation-Program/documents/mac/kwtestvectors.zip")
+		fmt.Println(" 8. unzip kwtestvectors.zip -d kwtestvectors")
+		fmt.Println(" 9. rm kwtestvectors.zip")
+		fmt.Println(" 10. wget https://csrc.nist.gov/CSRC/media/Projects/Cryptographic-Algorithm-Validation-Program/documents/mac/cmactestvectors.zip")
+		fmt.Println(" 11. unzip cmactestvectors.zip -d cmactestvectors")
+		fmt.Println(" 12. rm cmactestvectors.zip")
+		fmt.Println(" 13. wget https://csrc.nist.gov/CSRC/media/Projects/Cryptographic-Algorithm-Validation-Program/documents/aes/XTSTestVectors.zip")
+		fmt.Println(" 14. unzip -j XTSTestVectors.zip '*format tweak value input*' -d xtstestvectors")
+		fmt.Println(" 15. rm XTSTestVectors.zip")
 		os.Exit(911)
 	}
 }
 
 func Test_encryption(t *testing.T) {
+	aesgcm.WithEachBackend(t, encryptionVectors)
+}
+
+func encryptionVectors(t *testing.T) {
 	var Keylen, IVlen, PTlen, AADlen, Taglen, Count int // CAVP test fields
 	var Key, IV, PT, AAD, CT, Tag []byte                // CAVP test fields
 
@@ -67,7 +99,7 @@ func Test_encryption(t *testing.T) {
 			_, _ = fmt.Sscanf(line, "AAD = %x", &AAD)
 			_, _ = fmt.Sscanf(line, "CT = %x", &CT)
 			n, _ := fmt.Sscanf(line, "Tag = %x", &Tag)
-			if n > 0 && IVlen == 96 {
+			if n > 0 {
 				t.Run(fmt.Sprintf("testEncrypt with  %v  line  %d", fileName, lineNumber),
 					testEncrypt(IV, Key, PT[0:PTlen/8], AAD[0:AADlen/8], CT[0:PTlen/8], Tag, Taglen))
 			}
@@ -82,19 +114,88 @@ func testEncrypt(nonce, key, plainText, additionalData, cipherText, tag []byte,
 		var dst []byte
 		if testGolang {
 			block, _ := aes.NewCipher(key)
-			aesgcm1, _ = cipher.NewGCM(block)
+			aesgcm1, _ = cipher.NewGCMWithNonceSize(block, len(nonce))
 		} else {
-			aesgcm1 = aesgcm.NewAESGCM(key)
+			aesgcm1, _ = aesgcm.NewWithSizes(key, len(nonce), tagLen/8)
 		}
-		actual := aesgcm1.Seal(dst, nonce, plainText, additionalData) // Actual always gets a 128-bit tag
+		actual := aesgcm1.Seal(dst, nonce, plainText, additionalData) // Golang always gets a 128-bit tag
 		expected := append(cipherText, tag...)
-		if !bytes.Equal(expected, actual[0:len(actual)+tagLen/8-16]) {
+		if !bytes.Equal(expected, actual[0:len(actual)+tagLen/8-aesgcm1.Overhead()]) {
 			t.Error(fmt.Sprintf("\nExpected %x\nGot      %x\n", expected, actual)) //
 		}
 	}
 }
 
+// GMAC is GCM without plaintext, so the PTlen=0 encryption vectors double as GMAC vectors
+func Test_gmac(t *testing.T) {
+	aesgcm.WithEachBackend(t, gmacVectors)
+}
+
+func gmacVectors(t *testing.T) {
+	var Keylen, IVlen, PTlen, AADlen, Taglen, Count int // CAVP test fields
+	var Key, IV, PT, AAD, CT, Tag []byte                // CAVP test fields
+
+	for _, fileName := range testEncryptFiles {
+		var lineNumber int
+
+		fileHandle, err := os.Open(fileName)
+		if err != nil {
+			t.Error(fmt.Sprintf("Unable to open file: %v", fileName))
+			return
+		}
+
+		fileScanner := bufio.NewScanner(fileHandle)
+		for fileScanner.Scan() {
+			line := fileScanner.Text()
+			lineNumber++
+			// Slightly inefficient but effective
+			_, _ = fmt.Sscanf(line, "[Keylen = %d]", &Keylen)
+			_, _ = fmt.Sscanf(line, "[IVlen = %d]", &IVlen)
+			_, _ = fmt.Sscanf(line, "[PTlen = %d]", &PTlen)
+			_, _ = fmt.Sscanf(line, "[AADlen = %d]", &AADlen)
+			_, _ = fmt.Sscanf(line, "[Taglen = %d]", &Taglen)
+			_, _ = fmt.Sscanf(line, "Count = %d", &Count)
+			_, _ = fmt.Sscanf(line, "Key = %x", &Key)
+			_, _ = fmt.Sscanf(line, "IV = %x", &IV)
+			_, _ = fmt.Sscanf(line, "PT = %x", &PT)
+			_, _ = fmt.Sscanf(line, "AAD = %x", &AAD)
+			_, _ = fmt.Sscanf(line, "CT = %x", &CT)
+			n, _ := fmt.Sscanf(line, "Tag = %x", &Tag)
+			if n > 0 && PTlen == 0 {
+				t.Run(fmt.Sprintf("testGMAC with  %v  line  %d", fileName, lineNumber),
+					testGMAC(IV, Key, AAD[0:AADlen/8], Tag))
+			}
+		}
+		_ = fileHandle.Close()
+	}
+}
+
+func testGMAC(nonce, key, additionalData, tag []byte) func(*testing.T) {
+	return func(t *testing.T) {
+		gmac, err := aesgcm.NewGMAC(key, nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for remaining := additionalData; len(remaining) > 0; { // Uneven pieces to exercise the buffering
+			var piece = 1 + len(remaining)%7
+			if piece > len(remaining) {
+				piece = len(remaining)
+			}
+			_, _ = gmac.Write(remaining[:piece])
+			remaining = remaining[piece:]
+		}
+		actual := gmac.Sum(nil)
+		if !bytes.Equal(tag, actual[0:len(tag)]) {
+			t.Error(fmt.Sprintf("\nExpected %x\nGot      %x\n", tag, actual)) //
+		}
+	}
+}
+
 func Test_decryption(t *testing.T) {
+	aesgcm.WithEachBackend(t, decryptionVectors)
+}
+
+func decryptionVectors(t *testing.T) {
 	var Keylen, IVlen, PTlen, AADlen, Taglen, Count int // CAVP test fields
 	var Key, IV, PT, AAD, CT, Tag []byte                // CAVP test fields
 	var FAIL bool
@@ -132,7 +233,7 @@ func Test_decryption(t *testing.T) {
 				FAIL = true
 				PT = CT // just so the buffer size works
 			}
-			if (strings.Contains(line, "FAIL") || strings.Contains(line, "PT =")) && Taglen == 128 && IVlen == 96 {
+			if strings.Contains(line, "FAIL") || strings.Contains(line, "PT =") {
 				t.Run(fmt.Sprintf("testDecrypt with  %v  line  %d", fileName, lineNumber),
 					testDecrypt(IV, Key, PT[0:PTlen/8], AAD[0:AADlen/8], CT[0:PTlen/8], Tag[0:Taglen/8], FAIL, lineNumber))
 			}
@@ -147,9 +248,13 @@ func testDecrypt(nonce, key, plainText, additionalData, cipherText, tag []byte,
 		var dst []byte
 		if testGolang {
 			block, _ := aes.NewCipher(key)
-			aesgcm1, _ = cipher.NewGCM(block)
+			if len(tag) == 16 {
+				aesgcm1, _ = cipher.NewGCMWithNonceSize(block, len(nonce))
+			} else {
+				aesgcm1, _ = cipher.NewGCMWithTagSize(block, len(tag)) // Golang cannot combine both sizes
+			}
 		} else {
-			aesgcm1 = aesgcm.NewAESGCM(key)
+			aesgcm1, _ = aesgcm.NewWithSizes(key, len(nonce), len(tag))
 		}
 		if lineNumber == 4429 {
 			lineNumber++
@@ -0,0 +1,61 @@
+//go:build amd64 && !purego
+
+package aesgcm
+
+// Implemented in aesgcm_amd64.s
+
+//go:noescape
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+
+//go:noescape
+func encryptBlocksAsm(nr int, xk *byte, dst, src *byte, blocks int)
+
+//go:noescape
+func decryptBlocksAsm(nr int, xk *byte, dst, src *byte, blocks int)
+
+//go:noescape
+func gMulAsm(x, h *blockWord)
+
+//go:noescape
+func gHashBlocksAsm(y, hPowers *blockWord, blocks *byte, count int)
+
+// CPUID leaf 1, ECX bit 25 is AES-NI and bit 1 is PCLMULQDQ; the assembly also uses PSHUFB, which needs
+// SSSE3 (bit 9), and PEXTRQ/PINSRQ, which need SSE4.1 (bit 19)
+var supportsAsm = func() bool {
+	_, _, ecx, _ := cpuid(1, 0)
+	return ecx&(1<<25) != 0 && ecx&(1<<1) != 0 && ecx&(1<<9) != 0 && ecx&(1<<19) != 0
+}()
+
+func (aes *aesCipher) encryptBlocks(dst, src []byte) {
+	if aes.asm && len(src) > 0 {
+		_ = dst[len(src)-1] // Bounds check before handing raw pointers to assembly
+		encryptBlocksAsm(aes.nr, &aes.roundKeyBytes[0], &dst[0], &src[0], len(src)/16)
+		return
+	}
+	aes.encryptBlocksGeneric(dst, src)
+}
+
+func (aes *aesCipher) decryptBlocks(dst, src []byte) {
+	if aes.asm && len(src) > 0 {
+		_ = dst[len(src)-1]
+		decryptBlocksAsm(aes.nr, &aes.decRoundKeyBytes[0], &dst[0], &src[0], len(src)/16)
+		return
+	}
+	aes.decryptBlocksGeneric(dst, src)
+}
+
+func (aesgcm *aesgcm) gMul(x blockWord) blockWord {
+	if aesgcm.asm {
+		gMulAsm(&x, &aesgcm.h)
+		return x
+	}
+	return aesgcm.gMulGeneric(x)
+}
+
+func (aesgcm *aesgcm) gHashBlocks(blocks []byte, y blockWord) blockWord {
+	if aesgcm.asm && len(blocks) > 0 {
+		gHashBlocksAsm(&y, &aesgcm.hPowers[0], &blocks[0], len(blocks)/16)
+		return y
+	}
+	return aesgcm.gHashBlocksGeneric(blocks, y)
+}
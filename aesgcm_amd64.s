@@ -0,0 +1,249 @@
+//go:build amd64 && !purego
+
+#include "textflag.h"
+
+// A blockWord sits in memory as {left, right}; in registers it is kept as the 128-bit integer
+// left:right (PSHUFD $0x4e swaps the two halves), which is also a block loaded with bswapMask.
+
+DATA bswapMask<>+0x00(SB)/8, $0x08090a0b0c0d0e0f
+DATA bswapMask<>+0x08(SB)/8, $0x0001020304050607
+GLOBL bswapMask<>(SB), (NOPTR+RODATA), $16
+
+// func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+TEXT ·cpuid(SB), NOSPLIT, $0-24
+	MOVL eaxArg+0(FP), AX
+	MOVL ecxArg+4(FP), CX
+	CPUID
+	MOVL AX, eax+8(FP)
+	MOVL BX, ebx+12(FP)
+	MOVL CX, ecx+16(FP)
+	MOVL DX, edx+20(FP)
+	RET
+
+// func encryptBlocksAsm(nr int, xk *byte, dst, src *byte, blocks int)
+TEXT ·encryptBlocksAsm(SB), NOSPLIT, $0-40
+	MOVQ nr+0(FP), CX
+	MOVQ xk+8(FP), AX
+	MOVQ dst+16(FP), DI
+	MOVQ src+24(FP), SI
+	MOVQ blocks+32(FP), BX
+	TESTQ BX, BX
+	JZ   encryptDone
+
+encryptBlock:
+	MOVOU (SI), X0
+	MOVOU (AX), X1
+	PXOR  X1, X0
+	MOVQ  AX, DX
+	MOVQ  CX, R8
+	DECQ  R8
+
+encryptRound:
+	ADDQ   $16, DX
+	MOVOU  (DX), X1
+	AESENC X1, X0
+	DECQ   R8
+	JNZ    encryptRound
+
+	ADDQ       $16, DX
+	MOVOU      (DX), X1
+	AESENCLAST X1, X0
+	MOVOU      X0, (DI)
+	ADDQ       $16, SI
+	ADDQ       $16, DI
+	DECQ       BX
+	JNZ        encryptBlock
+
+encryptDone:
+	RET
+
+// func decryptBlocksAsm(nr int, xk *byte, dst, src *byte, blocks int)
+TEXT ·decryptBlocksAsm(SB), NOSPLIT, $0-40
+	MOVQ nr+0(FP), CX
+	MOVQ xk+8(FP), AX
+	MOVQ dst+16(FP), DI
+	MOVQ src+24(FP), SI
+	MOVQ blocks+32(FP), BX
+	TESTQ BX, BX
+	JZ   decryptDone
+
+decryptBlock:
+	MOVOU (SI), X0
+	MOVOU (AX), X1
+	PXOR  X1, X0
+	MOVQ  AX, DX
+	MOVQ  CX, R8
+	DECQ  R8
+
+decryptRound:
+	ADDQ   $16, DX
+	MOVOU  (DX), X1
+	AESDEC X1, X0
+	DECQ   R8
+	JNZ    decryptRound
+
+	ADDQ       $16, DX
+	MOVOU      (DX), X1
+	AESDECLAST X1, X0
+	MOVOU      X0, (DI)
+	ADDQ       $16, SI
+	ADDQ       $16, DI
+	DECQ       BX
+	JNZ        decryptBlock
+
+decryptDone:
+	RET
+
+// X0 = X0 * X1 in GF(2^128), the same steps as gMulGeneric: carry-less multiply into v3:v2 (X3) and
+// v1:v0 (X2), shift left one through R8-R11 and reduce. Clobbers X2, X3, X4, AX and R8-R11.
+#define GMUL \
+	MOVOU     X0, X2                    \
+	PCLMULQDQ $0x00, X1, X2             \
+	MOVOU     X0, X3                    \
+	PCLMULQDQ $0x11, X1, X3             \
+	MOVOU     X0, X4                    \
+	PCLMULQDQ $0x01, X1, X4             \
+	PCLMULQDQ $0x10, X1, X0             \
+	PXOR      X4, X0                    \
+	MOVOU     X0, X4                    \
+	PSLLDQ    $8, X4                    \
+	PXOR      X4, X2                    \
+	PSRLDQ    $8, X0                    \
+	PXOR      X0, X3                    \
+	REDUCE256
+
+// X0 = the 256-bit product X3:X2 shifted left one and reduced, as reduce256. Clobbers AX and R8-R11.
+#define REDUCE256 \
+	MOVQ      X2, R8                    \
+	PEXTRQ    $1, X2, R9                \
+	MOVQ      X3, R10                   \
+	PEXTRQ    $1, X3, R11               \
+	ADDQ      R8, R8                    \
+	ADCQ      R9, R9                    \
+	ADCQ      R10, R10                  \
+	ADCQ      R11, R11                  \
+	REDUCE(R8, R9, R10)                 \
+	REDUCE(R9, R10, R11)                \
+	MOVQ      R10, X0                   \
+	PINSRQ    $1, R11, X0
+
+// hi ^= lo ^ (lo >> 1) ^ (lo >> 2) ^ (lo >> 7); mid ^= (lo << 63) ^ (lo << 62) ^ (lo << 57)
+#define REDUCE(lo, mid, hi) \
+	XORQ lo, hi             \
+	MOVQ lo, AX             \
+	SHRQ $1, AX             \
+	XORQ AX, hi             \
+	MOVQ lo, AX             \
+	SHRQ $2, AX             \
+	XORQ AX, hi             \
+	MOVQ lo, AX             \
+	SHRQ $7, AX             \
+	XORQ AX, hi             \
+	MOVQ lo, AX             \
+	SHLQ $63, AX            \
+	XORQ AX, mid            \
+	MOVQ lo, AX             \
+	SHLQ $62, AX            \
+	XORQ AX, mid            \
+	MOVQ lo, AX             \
+	SHLQ $57, AX            \
+	XORQ AX, mid
+
+// func gMulAsm(x, h *blockWord)
+TEXT ·gMulAsm(SB), NOSPLIT, $0-16
+	MOVQ   x+0(FP), DI
+	MOVQ   h+8(FP), SI
+	MOVOU  (DI), X0
+	PSHUFD $0x4e, X0, X0
+	MOVOU  (SI), X1
+	PSHUFD $0x4e, X1, X1
+	GMUL
+	PSHUFD $0x4e, X0, X0
+	MOVOU  X0, (DI)
+	RET
+
+// Accumulate the unreduced product of block X2 and the power of H at hOffset(SI) into X6 (low), X7 (high)
+// and X8 (middle), as gProduct.add. Clobbers X1, X2 and X3.
+#define GMULACC(hOffset) \
+	MOVOU     hOffset(SI), X1           \
+	PSHUFD    $0x4e, X1, X1             \
+	MOVOU     X2, X3                    \
+	PCLMULQDQ $0x00, X1, X3             \
+	PXOR      X3, X6                    \
+	MOVOU     X2, X3                    \
+	PCLMULQDQ $0x11, X1, X3             \
+	PXOR      X3, X7                    \
+	MOVOU     X2, X3                    \
+	PCLMULQDQ $0x01, X1, X3             \
+	PXOR      X3, X8                    \
+	PCLMULQDQ $0x10, X1, X2             \
+	PXOR      X2, X8
+
+// Block i of the group of eight at DX, multiplied by H^(8-i)
+#define GHASHBLOCK(blockOffset, hOffset) \
+	MOVOU  blockOffset(DX), X2          \
+	PSHUFB X5, X2                       \
+	GMULACC(hOffset)
+
+// func gHashBlocksAsm(y, hPowers *blockWord, blocks *byte, count int)
+//
+// hPowers is H^1..H^8. Eight blocks at a time share one reduction as (y ^ X1)*H^8 ^ X2*H^7 ^ ... ^ X8*H,
+// the aggregated reduction of gHashBlocksGeneric; the remaining blocks are multiplied by H one at a time.
+TEXT ·gHashBlocksAsm(SB), NOSPLIT, $0-32
+	MOVQ   y+0(FP), DI
+	MOVQ   hPowers+8(FP), SI
+	MOVQ   blocks+16(FP), DX
+	MOVQ   count+24(FP), CX
+	MOVOU  (DI), X0
+	PSHUFD $0x4e, X0, X0
+	MOVOU  bswapMask<>(SB), X5
+	CMPQ   CX, $8
+	JB     gHashSingle
+
+gHashEight:
+	PXOR   X6, X6
+	PXOR   X7, X7
+	PXOR   X8, X8
+	MOVOU  (DX), X2
+	PSHUFB X5, X2
+	PXOR   X0, X2
+	GMULACC(112)
+	GHASHBLOCK(16, 96)
+	GHASHBLOCK(32, 80)
+	GHASHBLOCK(48, 64)
+	GHASHBLOCK(64, 48)
+	GHASHBLOCK(80, 32)
+	GHASHBLOCK(96, 16)
+	GHASHBLOCK(112, 0)
+	MOVOU  X8, X4
+	PSLLDQ $8, X4
+	PXOR   X4, X6
+	PSRLDQ $8, X8
+	PXOR   X8, X7
+	MOVOU  X6, X2
+	MOVOU  X7, X3
+	REDUCE256
+	ADDQ   $128, DX
+	SUBQ   $8, CX
+	CMPQ   CX, $8
+	JAE    gHashEight
+
+gHashSingle:
+	MOVOU  (SI), X1
+	PSHUFD $0x4e, X1, X1
+	TESTQ  CX, CX
+	JZ     gHashDone
+
+gHashBlock:
+	MOVOU  (DX), X2
+	PSHUFB X5, X2
+	PXOR   X2, X0
+	GMUL
+	ADDQ   $16, DX
+	DECQ   CX
+	JNZ    gHashBlock
+
+gHashDone:
+	PSHUFD $0x4e, X0, X0
+	MOVOU  X0, (DI)
+	RET
@@ -0,0 +1,21 @@
+//go:build !amd64 || purego
+
+package aesgcm
+
+const supportsAsm = false
+
+func (aes *aesCipher) encryptBlocks(dst, src []byte) {
+	aes.encryptBlocksGeneric(dst, src)
+}
+
+func (aes *aesCipher) decryptBlocks(dst, src []byte) {
+	aes.decryptBlocksGeneric(dst, src)
+}
+
+func (aesgcm *aesgcm) gMul(x blockWord) blockWord {
+	return aesgcm.gMulGeneric(x)
+}
+
+func (aesgcm *aesgcm) gHashBlocks(blocks []byte, y blockWord) blockWord {
+	return aesgcm.gHashBlocksGeneric(blocks, y)
+}
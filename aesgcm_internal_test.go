@@ -1,7 +1,7 @@
 package aesgcm
 
 // Internal tests for aes.go and gcm.go
-// go test aesgcm.go aes.go gcm.go tables.go aesgcm_internal_test.go -coverprofile cover.out
+// go test aesgcm.go aes.go gcm.go aesgcm_internal_test.go -coverprofile cover.out
 //
 
 // See "FIPS PUB 197" at https://nvlpubs.nist.gov/nistpubs/FIPS/NIST.FIPS.197.pdf
@@ -9,9 +9,11 @@ package aesgcm
 // See "GCM Operation" at http://luca-giuzzi.unibs.it/corsi/Support/papers-cryptography/gcm-spec.pdf
 
 import (
+	"crypto/aes"
 	"crypto/cipher"
 	"encoding/hex"
 	"fmt"
+	"math/rand"
 	"runtime/debug"
 	"testing"
 )
@@ -27,6 +29,17 @@ func assertEqualsString(t *testing.T, expected string, actual string) {
 	}
 }
 
+// WithEachBackend runs test against the pure Go code and, where the CPU supports it, the assembly code
+func WithEachBackend(t *testing.T, test func(t *testing.T)) {
+	defer func(saved bool) { useAsm = saved }(useAsm)
+	useAsm = false
+	t.Run("generic", test)
+	if supportsAsm {
+		useAsm = true
+		t.Run("asm", test)
+	}
+}
+
 //
 // AES key expansion internals
 //
@@ -50,25 +63,25 @@ func Test_aes_subWord(t *testing.T) {
 //
 
 func Test_aes_keyExpansion_128(t *testing.T) {
-	var instance *aesgcm
+	var instance *aesCipher
 	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
-	instance = new(aesgcm).expandAesKey(key)
+	instance = new(aesCipher).expandAesKey(key)
 	actual := fmt.Sprintf("%08x - %08x", instance.expandedAesKey[3], instance.expandedAesKey[43])
 	assertEqualsString(t, "09cf4f3c - b6630ca6", actual) // FIPS PUB 197, Appendix A.1, pg 27,28
 }
 
 func Test_aes_keyExpansion_192(t *testing.T) {
-	var instance *aesgcm
+	var instance *aesCipher
 	key, _ := hex.DecodeString("8e73b0f7da0e6452c810f32b809079e562f8ead2522c6b7b")
-	instance = new(aesgcm).expandAesKey(key)
+	instance = new(aesCipher).expandAesKey(key)
 	actual := fmt.Sprintf("%08x - %08x", instance.expandedAesKey[3], instance.expandedAesKey[51])
 	assertEqualsString(t, "809079e5 - 01002202", actual) // FIPS PUB 197, Appendix A.2, pg 28,30
 }
 
 func Test_aes_keyExpansion_256(t *testing.T) {
-	var instance *aesgcm
+	var instance *aesCipher
 	key, _ := hex.DecodeString("603deb1015ca71be2b73aef0857d77811f352c073b6108d72d9810a30914dff4")
-	instance = new(aesgcm).expandAesKey(key)
+	instance = new(aesCipher).expandAesKey(key)
 	actual := fmt.Sprintf("%08x - %08x", instance.expandedAesKey[3], instance.expandedAesKey[59])
 	assertEqualsString(t, "857d7781 - 706c631e", actual) // FIPS PUB 197, Appendix A.3, pg 30,32
 }
@@ -77,6 +90,50 @@ func Test_aes_keyExpansion_256(t *testing.T) {
 // AES cipher internals
 //
 
+// The round functions work on bitsliced state, so the FIPS 197 examples are sliced in and out of lane 0
+
+func sliceState(state [4][4]byte) bitslice {
+	var q bitslice
+	var block = make([]byte, 16)
+	for row := 0; row < 4; row++ {
+		for col := 0; col < 4; col++ {
+			block[col*4+row] = state[row][col]
+		}
+	}
+	q.load(block, 0)
+	return q
+}
+
+func unsliceState(q bitslice) [4][4]byte {
+	var state [4][4]byte
+	var block = make([]byte, 16)
+	q.store(block, 0)
+	for row := 0; row < 4; row++ {
+		for col := 0; col < 4; col++ {
+			state[row][col] = block[col*4+row]
+		}
+	}
+	return state
+}
+
+func xtime(x byte) byte {
+	var q = sliceState([4][4]byte{{x}})
+	q.xtime()
+	return unsliceState(q)[0][0]
+}
+
+// Scalar reference multiplication in GF(2^8), only used to cross-check the bitsliced mixColumns
+func mulMod(x, y byte) byte {
+	var result byte
+	for i := uint(0); i < 8; i++ {
+		if y&(1<<i) != 0x00 {
+			result = result ^ x
+		}
+		x = x<<1 ^ (x>>7)*0x1b
+	}
+	return result
+}
+
 func Test_aes_xtime(t *testing.T) {
 	var xt byte
 
@@ -111,13 +168,34 @@ func Test_aes_mulMod(t *testing.T) {
 }
 
 func Test_aes_subBytes(t *testing.T) {
-	var instance = new(aesgcm)
-	instance.state = [4][4]byte{{0x19, 0xa0, 0x9a, 0xe9}, {0x3d, 0xf4, 0xc6, 0xf8}, {0xe3, 0xe2, 0x8d, 0x48}, {0xbe, 0x2b, 0x2a, 0x08}}
-	instance.subBytes()
-	actual := fmt.Sprintf("%08x", instance.state)
+	var q = sliceState([4][4]byte{{0x19, 0xa0, 0x9a, 0xe9}, {0x3d, 0xf4, 0xc6, 0xf8}, {0xe3, 0xe2, 0x8d, 0x48}, {0xbe, 0x2b, 0x2a, 0x08}})
+	q.subBytes()
+	actual := fmt.Sprintf("%08x", unsliceState(q))
 	assertEqualsString(t, "[d4e0b81e 27bfb441 11985d52 aef1e530]", actual) // FIPS PUB 197, Appendix B, pg 33, round=1
 }
 
+// Confirm the S-box circuit matches the table for every input, in every lane
+func Test_aes_subBytes_allInputs(t *testing.T) {
+	var input, output = make([]byte, 64), make([]byte, 64)
+	for pass := 0; pass < 4; pass++ {
+		var q bitslice
+		for i := range input {
+			input[i] = byte(pass*64 + i)
+		}
+		for block := 0; block < 4; block++ {
+			q.load(input[block*16:], block)
+		}
+		q.subBytes()
+		for block := 0; block < 4; block++ {
+			q.store(output[block*16:], block)
+		}
+		for i := range input {
+			actual := fmt.Sprintf("%02x", output[i])
+			assertEqualsString(t, fmt.Sprintf("%02x", sBox[input[i]>>4][input[i]&0x0f]), actual) // FIPS PUB 197, Figure 7, pg 16
+		}
+	}
+}
+
 // Confirm that the tables are fully invertible via round trip substitution
 func Test_aes_roundTrips(t *testing.T) {
 	for row := 0; row < 16; row++ {
@@ -135,64 +213,170 @@ func Test_aes_roundTrips(t *testing.T) {
 }
 
 func Test_aes_shiftRows(t *testing.T) {
-	var instance = new(aesgcm)
-	instance.state = [4][4]byte{{0xd4, 0xe0, 0xb8, 0x1e}, {0x27, 0xbf, 0xb4, 0x41}, {0x11, 0x98, 0x5d, 0x52}, {0xae, 0xf1, 0xe5, 0x30}}
-	instance.shiftRows()
-	actual := fmt.Sprintf("%08x", instance.state)
+	var q = sliceState([4][4]byte{{0xd4, 0xe0, 0xb8, 0x1e}, {0x27, 0xbf, 0xb4, 0x41}, {0x11, 0x98, 0x5d, 0x52}, {0xae, 0xf1, 0xe5, 0x30}})
+	q.shiftRows()
+	actual := fmt.Sprintf("%08x", unsliceState(q))
 	assertEqualsString(t, "[d4e0b81e bfb44127 5d521198 30aef1e5]", actual) // FIPS PUB 197, Appendix B, pg 33, round=1
 }
 
 func Test_aes_mixColumns(t *testing.T) {
-	var instance = new(aesgcm)
-	instance.state = [4][4]byte{{0xd4, 0xe0, 0xb8, 0x1e}, {0xbf, 0xb4, 0x41, 0x27}, {0x5d, 0x52, 0x11, 0x98}, {0x30, 0xae, 0xf1, 0xe5}}
-	instance.mixColumns()
-	actual := fmt.Sprintf("%08x", instance.state)
+	var q = sliceState([4][4]byte{{0xd4, 0xe0, 0xb8, 0x1e}, {0xbf, 0xb4, 0x41, 0x27}, {0x5d, 0x52, 0x11, 0x98}, {0x30, 0xae, 0xf1, 0xe5}})
+	q.mixColumns()
+	actual := fmt.Sprintf("%08x", unsliceState(q))
 	assertEqualsString(t, "[04e04828 66cbf806 8119d326 e59a7a4c]", actual) // FIPS PUB 197, Appendix B, pg 33, round=1
 }
 
+// Cross-check the bitsliced mixColumns against FIPS 197 equation 5.6 on random states
+func Test_aes_mixColumns_random(t *testing.T) {
+	for iterations := 0; iterations < 1000; iterations++ {
+		var state, expected [4][4]byte
+		for row := 0; row < 4; row++ {
+			for col := 0; col < 4; col++ {
+				state[row][col] = byte(rand.Intn(256))
+			}
+		}
+		for col := 0; col < 4; col++ {
+			for row := 0; row < 4; row++ {
+				expected[row][col] = mulMod(0x02, state[row][col]) ^ mulMod(0x03, state[(row+1)%4][col]) ^
+					state[(row+2)%4][col] ^ state[(row+3)%4][col]
+			}
+		}
+		var q = sliceState(state)
+		q.mixColumns()
+		assertEqualsString(t, fmt.Sprintf("%08x", expected), fmt.Sprintf("%08x", unsliceState(q)))
+	}
+}
+
+func Test_aes_invSubBytes_allInputs(t *testing.T) {
+	var input, output = make([]byte, 64), make([]byte, 64)
+	for pass := 0; pass < 4; pass++ {
+		var q bitslice
+		for i := range input {
+			input[i] = byte(pass*64 + i)
+		}
+		for block := 0; block < 4; block++ {
+			q.load(input[block*16:], block)
+		}
+		q.invSubBytes()
+		for block := 0; block < 4; block++ {
+			q.store(output[block*16:], block)
+		}
+		for i := range input {
+			actual := fmt.Sprintf("%02x", output[i])
+			assertEqualsString(t, fmt.Sprintf("%02x", invSBox[input[i]>>4][input[i]&0x0f]), actual) // FIPS PUB 197, Figure 14, pg 22
+		}
+	}
+}
+
+func Test_aes_invShiftRows(t *testing.T) {
+	var q = sliceState([4][4]byte{{0xd4, 0xe0, 0xb8, 0x1e}, {0xbf, 0xb4, 0x41, 0x27}, {0x5d, 0x52, 0x11, 0x98}, {0x30, 0xae, 0xf1, 0xe5}})
+	q.invShiftRows()
+	actual := fmt.Sprintf("%08x", unsliceState(q))
+	assertEqualsString(t, "[d4e0b81e 27bfb441 11985d52 aef1e530]", actual) // FIPS PUB 197, Appendix B, pg 33, round=1 in reverse
+}
+
+func Test_aes_invMixColumns(t *testing.T) {
+	var q = sliceState([4][4]byte{{0x04, 0xe0, 0x48, 0x28}, {0x66, 0xcb, 0xf8, 0x06}, {0x81, 0x19, 0xd3, 0x26}, {0xe5, 0x9a, 0x7a, 0x4c}})
+	q.invMixColumns()
+	actual := fmt.Sprintf("%08x", unsliceState(q))
+	assertEqualsString(t, "[d4e0b81e bfb44127 5d521198 30aef1e5]", actual) // FIPS PUB 197, Appendix B, pg 33, round=1 in reverse
+}
+
 func Test_aes_addRoundKey(t *testing.T) {
-	var instance = new(aesgcm)
-	instance.state = [4][4]byte{{0x04, 0xe0, 0x48, 0x28}, {0x66, 0xcb, 0xf8, 0x06}, {0x81, 0x19, 0xd3, 0x26}, {0xe5, 0x9a, 0x7a, 0x4c}}
-	instance.expandedAesKey[4], instance.expandedAesKey[5], instance.expandedAesKey[6], instance.expandedAesKey[7] = 0xa0fafe17, 0x88542cb1, 0x23a33939, 0x2a6c7605
-	instance.addRoundKey(1)
-	actual := fmt.Sprintf("%08x", instance.state)
+	var q = sliceState([4][4]byte{{0x04, 0xe0, 0x48, 0x28}, {0x66, 0xcb, 0xf8, 0x06}, {0x81, 0x19, 0xd3, 0x26}, {0xe5, 0x9a, 0x7a, 0x4c}})
+	var roundKey bitslice
+	key, _ := hex.DecodeString("a0fafe1788542cb123a339392a6c7605")
+	roundKey.load(key, 0)
+	q.addRoundKey(&roundKey)
+	actual := fmt.Sprintf("%08x", unsliceState(q))
 	assertEqualsString(t, "[a4686b02 9c9f5b6a 7f35ea50 f22b4349]", actual) // FIPS PUB 197, Appendix B, pg 33, round=2
 }
 
 //
-// AES cipher; Note that decrypt functionality isn't actually needed for GCM
+// AES cipher and inverse cipher; GCM only encrypts, the inverse serves NewCipher, XTS and key unwrap
 //
 
 func Test_aes_encrypt_128(t *testing.T) {
-	var cText []byte
+	var cText [16]byte
 	key, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
-	instance := new(aesgcm).expandAesKey(key)
+	instance := new(aesCipher).expandAesKey(key)
 	pText, _ := hex.DecodeString("00112233445566778899aabbccddeeff")
-	cText = instance.encrypt(pText)
+	copy(cText[:], pText)
+	cText = instance.encrypt(cText)
 	actual := fmt.Sprintf("%032x", cText)
 	assertEqualsString(t, "69c4e0d86a7b0430d8cdb78070b4c55a", actual) // FIPS PUB 197, Appendix C.1, pg 35-36
 }
 
 func Test_aes_encrypt_192(t *testing.T) {
-	var cText []byte
+	var cText [16]byte
 	key, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f1011121314151617")
-	instance := new(aesgcm).expandAesKey(key)
+	instance := new(aesCipher).expandAesKey(key)
 	pText, _ := hex.DecodeString("00112233445566778899aabbccddeeff")
-	cText = instance.encrypt(pText)
+	copy(cText[:], pText)
+	cText = instance.encrypt(cText)
 	actual := fmt.Sprintf("%032x", cText)
 	assertEqualsString(t, "dda97ca4864cdfe06eaf70a0ec0d7191", actual) // FIPS PUB 197, Appendix C.2, pg 38-40
 }
 
 func Test_aes_encrypt_256(t *testing.T) {
-	var cText []byte
+	var cText [16]byte
 	key, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
-	instance := new(aesgcm).expandAesKey(key)
+	instance := new(aesCipher).expandAesKey(key)
 	pText, _ := hex.DecodeString("00112233445566778899aabbccddeeff")
-	cText = instance.encrypt(pText)
+	copy(cText[:], pText)
+	cText = instance.encrypt(cText)
 	actual := fmt.Sprintf("%032x", cText)
 	assertEqualsString(t, "8ea2b7ca516745bfeafc49904b496089", actual) // FIPS PUB 197, Appendix C.3, pg 42-43
 }
 
+func Test_aes_decrypt_128(t *testing.T) {
+	var pText = make([]byte, 16)
+	key, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	instance := new(aesCipher).expandAesKey(key)
+	cText, _ := hex.DecodeString("69c4e0d86a7b0430d8cdb78070b4c55a")
+	instance.decryptBlocks(pText, cText)
+	actual := fmt.Sprintf("%032x", pText)
+	assertEqualsString(t, "00112233445566778899aabbccddeeff", actual) // FIPS PUB 197, Appendix C.1, pg 36-37
+}
+
+func Test_aes_decrypt_192(t *testing.T) {
+	var pText = make([]byte, 16)
+	key, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f1011121314151617")
+	instance := new(aesCipher).expandAesKey(key)
+	cText, _ := hex.DecodeString("dda97ca4864cdfe06eaf70a0ec0d7191")
+	instance.decryptBlocks(pText, cText)
+	actual := fmt.Sprintf("%032x", pText)
+	assertEqualsString(t, "00112233445566778899aabbccddeeff", actual) // FIPS PUB 197, Appendix C.2, pg 40-41
+}
+
+func Test_aes_decrypt_256(t *testing.T) {
+	var pText = make([]byte, 16)
+	key, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	instance := new(aesCipher).expandAesKey(key)
+	cText, _ := hex.DecodeString("8ea2b7ca516745bfeafc49904b496089")
+	instance.decryptBlocks(pText, cText)
+	actual := fmt.Sprintf("%032x", pText)
+	assertEqualsString(t, "00112233445566778899aabbccddeeff", actual) // FIPS PUB 197, Appendix C.3, pg 43-45
+}
+
+// Up to four blocks are encrypted in parallel lanes; each lane must match crypto/aes
+func Test_aes_encryptBlocks(t *testing.T) {
+	for iterations := 0; iterations < 300; iterations++ {
+		var key = make([]byte, 16+8*rand.Intn(3))
+		rand.Read(key)
+		var pText = make([]byte, 16*(1+rand.Intn(4)))
+		rand.Read(pText)
+		var cText = make([]byte, len(pText))
+		new(aesCipher).expandAesKey(key).encryptBlocks(cText, pText)
+		var expected = make([]byte, len(pText))
+		var block, _ = aes.NewCipher(key)
+		for index := 0; index < len(pText); index = index + 16 {
+			block.Encrypt(expected[index:], pText[index:])
+		}
+		assertEqualsString(t, fmt.Sprintf("%x", expected), fmt.Sprintf("%x", cText))
+	}
+}
+
 //
 // GCM internals
 //
@@ -223,6 +407,23 @@ func Test_gcm_initializeH(t *testing.T) {
 	assertEqualsString(t, "{b83b533708bf535d 0aa6e52980d53b78}", actual) // GCM Operation, Appendix B, Test Case 3, pg 28
 }
 
+func Test_gcm_aggregatedReduction(t *testing.T) { // Against one reduction per block
+	for iterations := 0; iterations < 300; iterations++ {
+		var key = make([]byte, 16)
+		rand.Read(key)
+		var instance = NewAESGCM(key).(*aesgcm)
+		var y = blockWord{rand.Uint64(), rand.Uint64()}
+		var blocks = make([]byte, 16*rand.Intn(40))
+		rand.Read(blocks)
+		var expected = y
+		for index := 0; index < len(blocks); index += 16 {
+			expected = instance.gMulGeneric(bwXor(expected, bytes2bWord(blocks[index:index+16])))
+		}
+		var actual = instance.gHashBlocksGeneric(blocks, y)
+		assertEqualsString(t, fmt.Sprintf("%016x", expected), fmt.Sprintf("%016x", actual))
+	}
+}
+
 //
 // Deleted a bunch of gHash multiplication tests for earlier algorithm ... worth back-filling here
 //
@@ -246,3 +447,345 @@ func Test_gcm_incM32(t *testing.T) {
 	actual = fmt.Sprintf("%016x", incResult)
 	assertEqualsString(t, "{cafebabefacedbad decaf88800000004}", actual) // GCM operation, Appendix B, Test Case 3, pg 28
 }
+
+//
+// Assembly backend against the pure Go code
+//
+
+func Test_asm_cipherBlocks(t *testing.T) {
+	if !supportsAsm {
+		t.Skip("No assembly backend on this CPU or build")
+	}
+	for iterations := 0; iterations < 300; iterations++ {
+		var key = make([]byte, 16+8*rand.Intn(3))
+		rand.Read(key)
+		var instance = new(aesCipher).expandAesKey(key)
+		var pText = make([]byte, 16*(1+rand.Intn(4)))
+		rand.Read(pText)
+		var generic, asm = make([]byte, len(pText)), make([]byte, len(pText))
+		instance.asm = false
+		instance.encryptBlocks(generic, pText)
+		instance.asm = true
+		instance.encryptBlocks(asm, pText)
+		assertEqualsString(t, fmt.Sprintf("%x", generic), fmt.Sprintf("%x", asm))
+
+		instance.asm = false
+		instance.decryptBlocks(generic, pText)
+		instance.asm = true
+		instance.decryptBlocks(asm, pText)
+		assertEqualsString(t, fmt.Sprintf("%x", generic), fmt.Sprintf("%x", asm))
+	}
+}
+
+func Test_asm_gHash(t *testing.T) {
+	if !supportsAsm {
+		t.Skip("No assembly backend on this CPU or build")
+	}
+	for iterations := 0; iterations < 300; iterations++ {
+		var key = make([]byte, 16)
+		rand.Read(key)
+		var instance = NewAESGCM(key).(*aesgcm)
+		var x = blockWord{rand.Uint64(), rand.Uint64()}
+		var blocks = make([]byte, 16*rand.Intn(40)) // Past eight blocks, for the aggregated reduction
+		rand.Read(blocks)
+		instance.asm = false
+		var genericMul, genericHash = instance.gMul(x), instance.gHashBlocks(blocks, x)
+		instance.asm = true
+		var asmMul, asmHash = instance.gMul(x), instance.gHashBlocks(blocks, x)
+		assertEqualsString(t, fmt.Sprintf("%016x", genericMul), fmt.Sprintf("%016x", asmMul))
+		assertEqualsString(t, fmt.Sprintf("%016x", genericHash), fmt.Sprintf("%016x", asmHash))
+	}
+}
+
+//
+// AES-GCM-SIV internals
+//
+
+func Test_gcmsiv_polyval(t *testing.T) { // RFC 8452 Appendix A
+	WithEachBackend(t, func(t *testing.T) {
+		h, _ := hex.DecodeString("25629347589242761d31f826ba4b757b")
+		x, _ := hex.DecodeString("4f4f95668c83dfb6401762bb2d01a262d1a24ddd2721d006bbe45f20d3c9f362")
+		var instance = new(aesgcm)
+		instance.asm = useAsm
+		reverseBlock(h)
+		instance.setH(mulX(bytes2bWord(h)))
+		var s [16]byte
+		putBlockWord(s[:], instance.polyval(x, blockWord{0, 0}))
+		reverseBlock(s[:])
+		assertEqualsString(t, "f7a3b47b846119fae5b7866cf5e5b77e", hex.EncodeToString(s[:]))
+	})
+}
+
+//
+// XTS test exports
+//
+
+// XTSWithTweak runs XTS with a raw 128-bit tweak value, as given by the CAVP XTSGen files
+func XTSWithTweak(xts *XTS, dst, src, tweak []byte, encrypt bool) {
+	var i [16]byte
+	copy(i[:], tweak)
+	xts.crypt(dst, src, i, encrypt)
+}
+
+//
+// Random nonce invocation limit
+//
+
+func Test_RandomSealer_limit(t *testing.T) {
+	sealer, _ := NewRandomSealer(make([]byte, 16))
+	sealer.invocations = maxRandomInvocations - 1
+	if _, err := sealer.SealRandom(nil, []byte("last"), nil); err != nil {
+		t.Fatal("The 2^32nd invocation was refused:", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := sealer.SealRandom(nil, []byte("one too many"), nil); err != ErrNonceExhausted {
+			t.Error("Invocation past 2^32 accepted:", err)
+		}
+	}
+}
+
+//
+// SP 800-38D length limits
+//
+
+// withFakeLength makes lengthOf report fake for any slice of exactly marker bytes
+func withFakeLength(marker int, fake uint64, test func()) {
+	defer func(saved func([]byte) uint64) { lengthOf = saved }(lengthOf)
+	lengthOf = func(b []byte) uint64 {
+		if len(b) == marker {
+			return fake
+		}
+		return uint64(len(b))
+	}
+	test()
+}
+
+func sealPanics(aead cipher.AEAD, plaintext, additionalData []byte) (recovered interface{}) {
+	defer func() { recovered = recover() }()
+	aead.Seal(nil, make([]byte, 12), plaintext, additionalData)
+	return nil
+}
+
+func Test_limits_Seal_Open(t *testing.T) {
+	var aead = NewAESGCM(make([]byte, 16))
+	var marked = make([]byte, 7)
+	var sealed = aead.Seal(nil, make([]byte, 12), marked, nil) // 23 bytes
+	withFakeLength(7, maxPlaintextSize, func() {
+		if recovered := sealPanics(aead, marked, nil); recovered != nil {
+			t.Error("Seal rejected the largest plaintext:", recovered)
+		}
+	})
+	withFakeLength(7, maxPlaintextSize+1, func() {
+		if recovered := sealPanics(aead, marked, nil); recovered != ErrMessageTooLarge {
+			t.Error("Seal accepted a plaintext over 2^39-256 bits:", recovered)
+		}
+	})
+	withFakeLength(23, maxPlaintextSize+16, func() {
+		if _, err := aead.Open(nil, make([]byte, 12), sealed, nil); err != nil {
+			t.Error("Open refused the largest ciphertext:", err)
+		}
+	})
+	withFakeLength(23, maxPlaintextSize+17, func() {
+		if _, err := aead.Open(nil, make([]byte, 12), sealed, nil); err != ErrMessageTooLarge {
+			t.Error("Open accepted a ciphertext over 2^39-256 bits:", err)
+		}
+	})
+	withFakeLength(7, maxAdditionalDataSize, func() {
+		if recovered := sealPanics(aead, nil, marked); recovered != nil {
+			t.Error("Seal rejected the largest AAD:", recovered)
+		}
+	})
+	withFakeLength(7, maxAdditionalDataSize+1, func() {
+		if recovered := sealPanics(aead, nil, marked); recovered != ErrMessageTooLarge {
+			t.Error("Seal accepted AAD over 2^64-1 bits:", recovered)
+		}
+		if _, err := aead.Open(nil, make([]byte, 12), sealed, marked); err != ErrMessageTooLarge {
+			t.Error("Open accepted AAD over 2^64-1 bits:", err)
+		}
+	})
+}
+
+func Test_limits_multipart(t *testing.T) { // Running totals are set directly rather than faked per call
+	encrypter, _ := NewEncrypter(make([]byte, 16), make([]byte, 12))
+	encrypter.lenA = maxAdditionalDataSize - 7
+	if err := encrypter.UpdateAAD(make([]byte, 7)); err != nil {
+		t.Error("UpdateAAD refused AAD up to the limit:", err)
+	}
+	if err := encrypter.UpdateAAD(make([]byte, 1)); err != ErrMessageTooLarge {
+		t.Error("UpdateAAD accepted AAD over 2^64-1 bits:", err)
+	}
+	encrypter.lenC = maxPlaintextSize - 7
+	if err := encrypter.Update(make([]byte, 7), make([]byte, 7)); err != nil {
+		t.Error("Update refused input up to the limit:", err)
+	}
+	if err := encrypter.Update(make([]byte, 1), make([]byte, 1)); err != ErrMessageTooLarge {
+		t.Error("Update accepted input over 2^39-256 bits:", err)
+	}
+
+	var marked = make([]byte, 7)
+	gmac, _ := NewGMAC(make([]byte, 16), make([]byte, 12))
+	withFakeLength(7, maxAdditionalDataSize+1, func() {
+		if n, err := gmac.Write(marked); n != 0 || err != ErrMessageTooLarge {
+			t.Error("GMAC accepted input over 2^64-1 bits:", err)
+		}
+	})
+}
+
+//
+// Key destruction
+//
+
+func destroyedPanics(use func()) (recovered interface{}) {
+	defer func() { recovered = recover() }()
+	use()
+	return nil
+}
+
+func Test_Destroy(t *testing.T) {
+	var key = make([]byte, 32)
+	rand.Read(key)
+	var aead = NewAESGCM(key)
+	var sealed = aead.Seal(nil, make([]byte, 12), []byte("plaintext"), nil)
+	aead.(Destroyer).Destroy()
+	var wiped = aead.(*aesgcm)
+	if wiped.expandedAesKey != [60]uint32{} || wiped.roundKeys != [15]bitslice{} ||
+		wiped.roundKeyBytes != [240]byte{} || wiped.decRoundKeyBytes != [240]byte{} ||
+		wiped.h != (blockWord{}) || wiped.hr != (blockWord{}) ||
+		wiped.hPowers != [8]blockWord{} || wiped.hPowersR != [8]blockWord{} {
+		t.Error("Destroy left key material behind")
+	}
+	if recovered := sealPanics(aead, []byte("plaintext"), nil); recovered != ErrDestroyed {
+		t.Error("Seal after Destroy did not panic with ErrDestroyed:", recovered)
+	}
+	if _, err := aead.Open(nil, make([]byte, 12), sealed, nil); err != ErrDestroyed {
+		t.Error("Open after Destroy:", err)
+	}
+
+	ccm, _ := NewAESCCM(key, 12, 16)
+	siv, _ := NewAESGCMSIV(key)
+	for _, aead := range []cipher.AEAD{ccm, siv} {
+		aead.(Destroyer).Destroy()
+		if recovered := sealPanics(aead, nil, nil); recovered != ErrDestroyed {
+			t.Errorf("%T Seal after Destroy: %v", aead, recovered)
+		}
+		if _, err := aead.Open(nil, make([]byte, 12), make([]byte, 16), nil); err != ErrDestroyed {
+			t.Errorf("%T Open after Destroy: %v", aead, err)
+		}
+	}
+
+	encrypter, _ := NewEncrypter(key, make([]byte, 12))
+	encrypter.Update(make([]byte, 5), make([]byte, 5)) // Leaves a partly used keystream block
+	encrypter.Destroy()
+	if encrypter.keyStream != [16]byte{} || encrypter.eky0 != (blockWord{}) || encrypter.gHash != (gHashState{}) {
+		t.Error("Destroy left per-message state behind")
+	}
+	if err := encrypter.Update(make([]byte, 5), make([]byte, 5)); err != ErrDestroyed {
+		t.Error("Update after Destroy:", err)
+	}
+	if tag := encrypter.Final(); tag != nil {
+		t.Error("Final after Destroy returned a tag")
+	}
+	decrypter, _ := NewDecrypter(key, make([]byte, 12))
+	decrypter.Destroy()
+	if err := decrypter.Verify(make([]byte, 16)); err != ErrDestroyed {
+		t.Error("Verify after Destroy:", err)
+	}
+
+	gmac, _ := NewGMAC(key, make([]byte, 12))
+	gmac.(Destroyer).Destroy()
+	if _, err := gmac.Write([]byte("data")); err != ErrDestroyed {
+		t.Error("GMAC Write after Destroy:", err)
+	}
+
+	xts, _ := NewXTS(make([]byte, 64))
+	xts.Destroy()
+	if xts.k1.expandedAesKey != [60]uint32{} || xts.k2.expandedAesKey != [60]uint32{} {
+		t.Error("XTS Destroy left key material behind")
+	}
+	if recovered := destroyedPanics(func() { xts.EncryptSector(make([]byte, 16), make([]byte, 16), 0) }); recovered != ErrDestroyed {
+		t.Error("EncryptSector after Destroy:", recovered)
+	}
+	aessiv, _ := NewAESSIV(key)
+	aessiv.Destroy()
+	if aessiv.mac.k1 != [16]byte{} || aessiv.ctr.expandedAesKey != [60]uint32{} {
+		t.Error("AESSIV Destroy left key material behind")
+	}
+	if _, err := aessiv.Open(nil, nil, make([]byte, 16)); err != ErrDestroyed {
+		t.Error("AESSIV Open after Destroy:", err)
+	}
+	mac, _ := NewCMAC(key)
+	mac.(Destroyer).Destroy()
+	if mac.(*cmac).k1 != [16]byte{} || mac.(*cmac).k2 != [16]byte{} {
+		t.Error("CMAC Destroy left the subkeys behind")
+	}
+	if _, err := mac.Write([]byte("data")); err != ErrDestroyed {
+		t.Error("CMAC Write after Destroy:", err)
+	}
+	block, _ := NewCipher(key)
+	block.(Destroyer).Destroy()
+	if recovered := destroyedPanics(func() { block.Encrypt(make([]byte, 16), make([]byte, 16)) }); recovered != ErrDestroyed {
+		t.Error("Encrypt after Destroy:", recovered)
+	}
+
+	sealer, _ := NewRandomSealer(key)
+	sealer.Destroy()
+	if _, err := sealer.SealRandom(nil, nil, nil); err != ErrDestroyed {
+		t.Error("SealRandom after Destroy:", err)
+	}
+}
+
+//
+// Streaming key derivation
+//
+
+func Test_stream_hkdfSHA256(t *testing.T) { // RFC 5869 Appendix A.1 and A.3
+	ikm, _ := hex.DecodeString("0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b")
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	assertEqualsString(t, "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865",
+		hex.EncodeToString(hkdfSHA256(ikm, salt, info, 42)))
+	assertEqualsString(t, "8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d9d201395faa4b61a96c8",
+		hex.EncodeToString(hkdfSHA256(ikm, nil, nil, 42)))
+}
+
+//
+// S-box tables, FIPS 197 Figures 7 and 14; the bitsliced S-box replaced them in the cipher
+//
+
+var sBox = [16][16]byte{
+	{0x63, 0x7c, 0x77, 0x7b, 0xf2, 0x6b, 0x6f, 0xc5, 0x30, 0x01, 0x67, 0x2b, 0xfe, 0xd7, 0xab, 0x76},
+	{0xca, 0x82, 0xc9, 0x7d, 0xfa, 0x59, 0x47, 0xf0, 0xad, 0xd4, 0xa2, 0xaf, 0x9c, 0xa4, 0x72, 0xc0},
+	{0xb7, 0xfd, 0x93, 0x26, 0x36, 0x3f, 0xf7, 0xcc, 0x34, 0xa5, 0xe5, 0xf1, 0x71, 0xd8, 0x31, 0x15},
+	{0x04, 0xc7, 0x23, 0xc3, 0x18, 0x96, 0x05, 0x9a, 0x07, 0x12, 0x80, 0xe2, 0xeb, 0x27, 0xb2, 0x75},
+	{0x09, 0x83, 0x2c, 0x1a, 0x1b, 0x6e, 0x5a, 0xa0, 0x52, 0x3b, 0xd6, 0xb3, 0x29, 0xe3, 0x2f, 0x84},
+	{0x53, 0xd1, 0x00, 0xed, 0x20, 0xfc, 0xb1, 0x5b, 0x6a, 0xcb, 0xbe, 0x39, 0x4a, 0x4c, 0x58, 0xcf},
+	{0xd0, 0xef, 0xaa, 0xfb, 0x43, 0x4d, 0x33, 0x85, 0x45, 0xf9, 0x02, 0x7f, 0x50, 0x3c, 0x9f, 0xa8},
+	{0x51, 0xa3, 0x40, 0x8f, 0x92, 0x9d, 0x38, 0xf5, 0xbc, 0xb6, 0xda, 0x21, 0x10, 0xff, 0xf3, 0xd2},
+	{0xcd, 0x0c, 0x13, 0xec, 0x5f, 0x97, 0x44, 0x17, 0xc4, 0xa7, 0x7e, 0x3d, 0x64, 0x5d, 0x19, 0x73},
+	{0x60, 0x81, 0x4f, 0xdc, 0x22, 0x2a, 0x90, 0x88, 0x46, 0xee, 0xb8, 0x14, 0xde, 0x5e, 0x0b, 0xdb},
+	{0xe0, 0x32, 0x3a, 0x0a, 0x49, 0x06, 0x24, 0x5c, 0xc2, 0xd3, 0xac, 0x62, 0x91, 0x95, 0xe4, 0x79},
+	{0xe7, 0xc8, 0x37, 0x6d, 0x8d, 0xd5, 0x4e, 0xa9, 0x6c, 0x56, 0xf4, 0xea, 0x65, 0x7a, 0xae, 0x08},
+	{0xba, 0x78, 0x25, 0x2e, 0x1c, 0xa6, 0xb4, 0xc6, 0xe8, 0xdd, 0x74, 0x1f, 0x4b, 0xbd, 0x8b, 0x8a},
+	{0x70, 0x3e, 0xb5, 0x66, 0x48, 0x03, 0xf6, 0x0e, 0x61, 0x35, 0x57, 0xb9, 0x86, 0xc1, 0x1d, 0x9e},
+	{0xe1, 0xf8, 0x98, 0x11, 0x69, 0xd9, 0x8e, 0x94, 0x9b, 0x1e, 0x87, 0xe9, 0xce, 0x55, 0x28, 0xdf},
+	{0x8c, 0xa1, 0x89, 0x0d, 0xbf, 0xe6, 0x42, 0x68, 0x41, 0x99, 0x2d, 0x0f, 0xb0, 0x54, 0xbb, 0x16},
+}
+
+var invSBox = [16][16]byte{
+	{0x52, 0x09, 0x6a, 0xd5, 0x30, 0x36, 0xa5, 0x38, 0xbf, 0x40, 0xa3, 0x9e, 0x81, 0xf3, 0xd7, 0xfb},
+	{0x7c, 0xe3, 0x39, 0x82, 0x9b, 0x2f, 0xff, 0x87, 0x34, 0x8e, 0x43, 0x44, 0xc4, 0xde, 0xe9, 0xcb},
+	{0x54, 0x7b, 0x94, 0x32, 0xa6, 0xc2, 0x23, 0x3d, 0xee, 0x4c, 0x95, 0x0b, 0x42, 0xfa, 0xc3, 0x4e},
+	{0x08, 0x2e, 0xa1, 0x66, 0x28, 0xd9, 0x24, 0xb2, 0x76, 0x5b, 0xa2, 0x49, 0x6d, 0x8b, 0xd1, 0x25},
+	{0x72, 0xf8, 0xf6, 0x64, 0x86, 0x68, 0x98, 0x16, 0xd4, 0xa4, 0x5c, 0xcc, 0x5d, 0x65, 0xb6, 0x92},
+	{0x6c, 0x70, 0x48, 0x50, 0xfd, 0xed, 0xb9, 0xda, 0x5e, 0x15, 0x46, 0x57, 0xa7, 0x8d, 0x9d, 0x84},
+	{0x90, 0xd8, 0xab, 0x00, 0x8c, 0xbc, 0xd3, 0x0a, 0xf7, 0xe4, 0x58, 0x05, 0xb8, 0xb3, 0x45, 0x06},
+	{0xd0, 0x2c, 0x1e, 0x8f, 0xca, 0x3f, 0x0f, 0x02, 0xc1, 0xaf, 0xbd, 0x03, 0x01, 0x13, 0x8a, 0x6b},
+	{0x3a, 0x91, 0x11, 0x41, 0x4f, 0x67, 0xdc, 0xea, 0x97, 0xf2, 0xcf, 0xce, 0xf0, 0xb4, 0xe6, 0x73},
+	{0x96, 0xac, 0x74, 0x22, 0xe7, 0xad, 0x35, 0x85, 0xe2, 0xf9, 0x37, 0xe8, 0x1c, 0x75, 0xdf, 0x6e},
+	{0x47, 0xf1, 0x1a, 0x71, 0x1d, 0x29, 0xc5, 0x89, 0x6f, 0xb7, 0x62, 0x0e, 0xaa, 0x18, 0xbe, 0x1b},
+	{0xfc, 0x56, 0x3e, 0x4b, 0xc6, 0xd2, 0x79, 0x20, 0x9a, 0xdb, 0xc0, 0xfe, 0x78, 0xcd, 0x5a, 0xf4},
+	{0x1f, 0xdd, 0xa8, 0x33, 0x88, 0x07, 0xc7, 0x31, 0xb1, 0x12, 0x10, 0x59, 0x27, 0x80, 0xec, 0x5f},
+	{0x60, 0x51, 0x7f, 0xa9, 0x19, 0xb5, 0x4a, 0x0d, 0x2d, 0xe5, 0x7a, 0x9f, 0x93, 0xc9, 0x9c, 0xef},
+	{0xa0, 0xe0, 0x3b, 0x4d, 0xae, 0x2a, 0xf5, 0xb0, 0xc8, 0xeb, 0xbb, 0x3c, 0x83, 0x53, 0x99, 0x61},
+	{0x17, 0x2b, 0x04, 0x7e, 0xba, 0x77, 0xd6, 0x26, 0xe1, 0x69, 0x14, 0x63, 0x55, 0x21, 0x0c, 0x7d},
+}
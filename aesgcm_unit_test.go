@@ -13,8 +13,11 @@ import (
 	"crypto/cipher"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/rand"
 	"runtime/debug"
+	"sync"
 	"testing"
 )
 
@@ -81,7 +84,39 @@ func Test_aesgcm_Seal_3blocks_fraction(t *testing.T) {
 	assertEqualsString(t, "42831ec2217774244b7221b784d0d49ce3aa212f2c02a4e035c17e2329aca12e21d514b25466931c7d8f6a5aac84aa051ba30b396a0aac973d58e091", actual)
 }
 
+//Test encryption with a short 8-byte nonce - test case 5
+func Test_aesgcm_Seal_nonce8(t *testing.T) {
+	var dst []byte // Not used, empty allocation (var is needed)
+	key, _ := hex.DecodeString("feffe9928665731c6d6a8f9467308308")
+	nonce, _ := hex.DecodeString("cafebabefacedbad")
+	plaintext, _ := hex.DecodeString("d9313225f88406e5a55909c5aff5269a86a7a9531534f7da2e4c303d8a318a721c3c0c95956809532fcf0e2449a6b525b16aedf5aa0de657ba637b39")
+	additionalData, _ := hex.DecodeString("feedfacedeadbeeffeedfacedeadbeefabaddad2")
+	instance := aesgcm.NewAESGCMWithNonceSize(key, len(nonce))
+	var cText []byte
+	cText = instance.Seal(dst, nonce, plaintext, additionalData)
+	actual := fmt.Sprintf("%x", cText)
+	assertEqualsString(t, "61353b4c2806934a777ff51fa22a4755699b2a714fcdc6f83766e5f97b6c742373806900e49f24b22b097544d4896b424989b5e1ebac0f07c23f4598"+"3612d2e79e3b0785561be14aaca2fccb", actual)
+}
+
+//Test encryption with a long 60-byte nonce - test case 6
+func Test_aesgcm_Seal_nonce60(t *testing.T) {
+	var dst []byte // Not used, empty allocation (var is needed)
+	key, _ := hex.DecodeString("feffe9928665731c6d6a8f9467308308")
+	nonce, _ := hex.DecodeString("9313225df88406e555909c5aff5269aa6a7a9538534f7da1e4c303d2a318a728c3c0c95156809539fcf0e2429a6b525416aedbf5a0de6a57a637b39b")
+	plaintext, _ := hex.DecodeString("d9313225f88406e5a55909c5aff5269a86a7a9531534f7da2e4c303d8a318a721c3c0c95956809532fcf0e2449a6b525b16aedf5aa0de657ba637b39")
+	additionalData, _ := hex.DecodeString("feedfacedeadbeeffeedfacedeadbeefabaddad2")
+	instance := aesgcm.NewAESGCMWithNonceSize(key, len(nonce))
+	var cText []byte
+	cText = instance.Seal(dst, nonce, plaintext, additionalData)
+	actual := fmt.Sprintf("%x", cText)
+	assertEqualsString(t, "8ce24998625615b603a033aca13fb894be9112a5c3a211a8ba262a3cca7e2ca701e4a9a4fba43c90ccdcb281d48c7c6fd62875d2aca417034c34aee5"+"619cc5aefffe0bfa462af43c1699d050", actual)
+}
+
 func Test_fuzz_Encrypt_Decrypt(t *testing.T) {
+	aesgcm.WithEachBackend(t, fuzzEncryptDecrypt)
+}
+
+func fuzzEncryptDecrypt(t *testing.T) {
 	for iterations := 0; iterations < 5000; iterations++ {
 
 		var length1 = rand.Intn(4000)
@@ -133,3 +168,1232 @@ func Test_fuzz_Encrypt_Decrypt(t *testing.T) {
 
 	}
 }
+
+func Test_fuzz_NonceSize(t *testing.T) {
+	for iterations := 0; iterations < 1000; iterations++ {
+
+		var message = make([]byte, rand.Intn(200))
+		rand.Read(message)
+
+		var additionalData = make([]byte, rand.Intn(200))
+		rand.Read(additionalData)
+
+		var key = make([]byte, 16+8*rand.Intn(3))
+		rand.Read(key)
+
+		var nonce = make([]byte, 1+rand.Intn(100))
+		rand.Read(nonce)
+
+		var aesBlock, _ = aes.NewCipher(key)
+		var golang, _ = cipher.NewGCMWithNonceSize(aesBlock, len(nonce))
+		var goCipherText = golang.Seal([]byte{}, nonce, message, additionalData)
+
+		var testInstance = aesgcm.NewAESGCMWithNonceSize(key, len(nonce))
+		var ciphertext = testInstance.Seal([]byte{}, nonce, message, additionalData)
+
+		if !bytes.Equal(goCipherText, ciphertext) {
+			t.Error(fmt.Sprintf("Encrypt fail with %d-byte nonce\nExpected   %x\nActual --> %x\n", len(nonce), goCipherText, ciphertext))
+		}
+
+		var plaintext, err = testInstance.Open([]byte{}, nonce, ciphertext, additionalData)
+		if err != nil || !bytes.Equal(message, plaintext) {
+			t.Error(fmt.Sprintf("Decrypt fail with %d-byte nonce\nExpected   %x\nActual --> %x\n", len(nonce), message, plaintext))
+		}
+	}
+}
+
+func Test_aesgcm_TagSize(t *testing.T) {
+	key, _ := hex.DecodeString("feffe9928665731c6d6a8f9467308308")
+	nonce, _ := hex.DecodeString("cafebabefacedbaddecaf888")
+	plaintext, _ := hex.DecodeString("d9313225f88406e5a55909c5aff5269a86a7a9531534f7da2e4c303d8a318a721c3c0c95956809532fcf0e2449a6b525b16aedf5aa0de657ba637b39")
+	additionalData, _ := hex.DecodeString("feedfacedeadbeeffeedfacedeadbeefabaddad2")
+	fullTag := "5bc94fbc3221a5db94fae95ae7121a47" // GCM Operation, Appendix B, Test Case 4, pg 28
+	for _, tagSize := range []int{16, 15, 14, 13, 12, 8, 4} {
+		instance := aesgcm.NewAESGCMWithTagSize(key, tagSize)
+		assertEqualsString(t, fmt.Sprintf("%d", tagSize), fmt.Sprintf("%d", instance.Overhead()))
+		cText := instance.Seal(nil, nonce, plaintext, additionalData)
+		actual := fmt.Sprintf("%x", cText[len(plaintext):])
+		assertEqualsString(t, fullTag[:2*tagSize], actual)
+
+		pText, err := instance.Open(nil, nonce, cText, additionalData)
+		if err != nil || !bytes.Equal(plaintext, pText) {
+			t.Error(fmt.Sprintf("Open failed with %d-byte tag", tagSize))
+		}
+		cText[len(cText)-1] ^= 0x01
+		if _, err = instance.Open(nil, nonce, cText, additionalData); err == nil {
+			t.Error(fmt.Sprintf("Open accepted a corrupted %d-byte tag", tagSize))
+		}
+	}
+}
+
+func Test_aesgcm_TagSize_rejected(t *testing.T) {
+	key := make([]byte, 16)
+	for _, tagSize := range []int{-1, 0, 1, 3, 5, 6, 7, 9, 10, 11, 17, 32} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error(fmt.Sprintf("Tag size %d was not rejected", tagSize))
+				}
+			}()
+			aesgcm.NewAESGCMWithTagSize(key, tagSize)
+		}()
+	}
+}
+
+// One shared AEAD used by many goroutines; run with -race to catch shared per-message state
+func Test_concurrent_Seal_Open(t *testing.T) {
+	var key = make([]byte, 32)
+	rand.Read(key)
+	var testInstance = aesgcm.NewAESGCM(key)
+	var aesBlock, _ = aes.NewCipher(key)
+	var golang, _ = cipher.NewGCM(aesBlock)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 16; worker++ {
+		var source = rand.New(rand.NewSource(int64(worker)))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for iterations := 0; iterations < 200; iterations++ {
+				var message = make([]byte, source.Intn(300))
+				source.Read(message)
+				var additionalData = make([]byte, source.Intn(300))
+				source.Read(additionalData)
+				var nonce = make([]byte, 12)
+				source.Read(nonce)
+
+				var ciphertext = testInstance.Seal(nil, nonce, message, additionalData)
+				if !bytes.Equal(golang.Seal(nil, nonce, message, additionalData), ciphertext) {
+					t.Error("Concurrent Seal produced a wrong ciphertext")
+					return
+				}
+				var plaintext, err = testInstance.Open(nil, nonce, ciphertext, additionalData)
+				if err != nil || !bytes.Equal(message, plaintext) {
+					t.Error("Concurrent Open failed")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Seal and Open must append to dst, byte-for-byte like crypto/cipher
+func Test_append_prefixed_dst(t *testing.T) {
+	for iterations := 0; iterations < 200; iterations++ {
+		var message = make([]byte, rand.Intn(100))
+		rand.Read(message)
+		var additionalData = make([]byte, rand.Intn(100))
+		rand.Read(additionalData)
+		var key = make([]byte, 16)
+		rand.Read(key)
+		var nonce = make([]byte, 12)
+		rand.Read(nonce)
+		var header = make([]byte, rand.Intn(40))
+		rand.Read(header)
+		var spare = rand.Intn(40) // Sometimes dst has room to grow in place, sometimes not
+
+		var aesBlock, _ = aes.NewCipher(key)
+		var golang, _ = cipher.NewGCM(aesBlock)
+		var testInstance = aesgcm.NewAESGCM(key)
+
+		var goSealed = golang.Seal(append(make([]byte, 0, len(header)+spare), header...), nonce, message, additionalData)
+		var sealed = testInstance.Seal(append(make([]byte, 0, len(header)+spare), header...), nonce, message, additionalData)
+		if !bytes.Equal(goSealed, sealed) {
+			t.Error(fmt.Sprintf("Seal with prefix fail\nExpected   %x\nActual --> %x\n", goSealed, sealed))
+		}
+
+		var goOpened, _ = golang.Open(append(make([]byte, 0, len(header)+spare), header...), nonce, sealed[len(header):], additionalData)
+		var opened, err = testInstance.Open(append(make([]byte, 0, len(header)+spare), header...), nonce, sealed[len(header):], additionalData)
+		if err != nil || !bytes.Equal(goOpened, opened) {
+			t.Error(fmt.Sprintf("Open with prefix fail\nExpected   %x\nActual --> %x\n", goOpened, opened))
+		}
+	}
+}
+
+// plaintext[:0] and ciphertext[:0] as dst encrypt and decrypt in place
+func Test_append_in_place(t *testing.T) {
+	for iterations := 0; iterations < 200; iterations++ {
+		var message = make([]byte, rand.Intn(100))
+		rand.Read(message)
+		var additionalData = make([]byte, rand.Intn(100))
+		rand.Read(additionalData)
+		var key = make([]byte, 16)
+		rand.Read(key)
+		var nonce = make([]byte, 12)
+		rand.Read(nonce)
+
+		var aesBlock, _ = aes.NewCipher(key)
+		var golang, _ = cipher.NewGCM(aesBlock)
+		var testInstance = aesgcm.NewAESGCM(key)
+		var goSealed = golang.Seal(nil, nonce, message, additionalData)
+
+		var buffer = make([]byte, len(message), len(message)+testInstance.Overhead())
+		copy(buffer, message)
+		var sealed = testInstance.Seal(buffer[:0], nonce, buffer, additionalData)
+		if !bytes.Equal(goSealed, sealed) || &sealed[0] != &buffer[:1][0] {
+			t.Error(fmt.Sprintf("Seal in place fail\nExpected   %x\nActual --> %x\n", goSealed, sealed))
+		}
+
+		var opened, err = testInstance.Open(sealed[:0], nonce, sealed, additionalData)
+		if err != nil || !bytes.Equal(message, opened) {
+			t.Error(fmt.Sprintf("Open in place fail\nExpected   %x\nActual --> %x\n", message, opened))
+		}
+	}
+}
+
+// Seal and Open allocate nothing once dst has the capacity, on either backend
+func Test_zero_allocations(t *testing.T) {
+	aesgcm.WithEachBackend(t, func(t *testing.T) {
+		for _, nonceSize := range []int{12, 8} { // 8 takes the GHASH path for J0
+			for _, length := range []int{0, 1, 16, 100, 1500} {
+				var key, nonce = make([]byte, 32), make([]byte, nonceSize)
+				var plaintext, additionalData = make([]byte, length), make([]byte, 13)
+				rand.Read(key)
+				rand.Read(plaintext)
+				var testInstance, _ = aesgcm.NewWithSizes(key, nonceSize, 12)
+				var sealed = make([]byte, 0, length+testInstance.Overhead())
+				var opened = make([]byte, 0, length)
+				var allocs = testing.AllocsPerRun(100, func() {
+					sealed = testInstance.Seal(sealed[:0], nonce, plaintext, additionalData)
+				})
+				if allocs != 0 {
+					t.Errorf("Seal of %d bytes with a %d-byte nonce: %v allocations", length, nonceSize, allocs)
+				}
+				allocs = testing.AllocsPerRun(100, func() {
+					opened, _ = testInstance.Open(opened[:0], nonce, sealed, additionalData)
+				})
+				if allocs != 0 || !bytes.Equal(plaintext, opened) {
+					t.Errorf("Open of %d bytes with a %d-byte nonce: %v allocations", length, nonceSize, allocs)
+				}
+				sealed[0] ^= 1 // Rejected input takes the same path up to the tag check
+				allocs = testing.AllocsPerRun(100, func() {
+					testInstance.Open(opened[:0], nonce, sealed, additionalData)
+				})
+				if allocs != 0 {
+					t.Errorf("failed Open of %d bytes with a %d-byte nonce: %v allocations", length, nonceSize, allocs)
+				}
+			}
+		}
+	})
+}
+
+func Test_append_inexact_overlap(t *testing.T) {
+	var testInstance = aesgcm.NewAESGCM(make([]byte, 16))
+	var buffer = make([]byte, 100)
+	defer func() {
+		if recover() == nil {
+			t.Error("Seal accepted an inexactly overlapping dst")
+		}
+	}()
+	testInstance.Seal(buffer[1:1], make([]byte, 12), buffer[:50], nil)
+}
+
+func Test_errors_constructors(t *testing.T) {
+	for _, keySize := range []int{0, 1, 15, 17, 23, 25, 31, 33, 64} {
+		if _, err := aesgcm.New(make([]byte, keySize)); err != aesgcm.ErrKeySize {
+			t.Error(fmt.Sprintf("Expected ErrKeySize for %d-byte key, got %v", keySize, err))
+		}
+	}
+	if _, err := aesgcm.NewWithSizes(make([]byte, 16), 0, 16); err != aesgcm.ErrNonceSize {
+		t.Error(fmt.Sprintf("Expected ErrNonceSize for empty nonce, got %v", err))
+	}
+	if _, err := aesgcm.NewWithSizes(make([]byte, 16), 12, 10); err != aesgcm.ErrTagSize {
+		t.Error(fmt.Sprintf("Expected ErrTagSize for 10-byte tag, got %v", err))
+	}
+	if _, err := aesgcm.NewWithSizes(make([]byte, 32), 8, 12); err != nil {
+		t.Error(fmt.Sprintf("Expected no error for valid sizes, got %v", err))
+	}
+	defer func() {
+		if recover() != aesgcm.ErrKeySize {
+			t.Error("NewAESGCM did not panic with ErrKeySize")
+		}
+	}()
+	aesgcm.NewAESGCM(make([]byte, 20))
+}
+
+// Open sees attacker-controlled input, so nothing malformed may panic
+func Test_errors_Open_malformed(t *testing.T) {
+	var testInstance, _ = aesgcm.NewWithSizes(make([]byte, 16), 12, 12)
+	var nonce = make([]byte, 12)
+	for length := 0; length < testInstance.Overhead(); length++ {
+		if _, err := testInstance.Open(nil, nonce, make([]byte, length), nil); err != aesgcm.ErrAuthFailed {
+			t.Error(fmt.Sprintf("Expected ErrAuthFailed for %d-byte ciphertext, got %v", length, err))
+		}
+	}
+	for _, nonceSize := range []int{0, 11, 13} {
+		if _, err := testInstance.Open(nil, make([]byte, nonceSize), make([]byte, 32), nil); err != aesgcm.ErrNonceSize {
+			t.Error(fmt.Sprintf("Expected ErrNonceSize for %d-byte nonce, got %v", nonceSize, err))
+		}
+	}
+	var sealed = testInstance.Seal(nil, nonce, []byte("message"), nil)
+	sealed[0] ^= 0x80
+	if _, err := testInstance.Open(nil, nonce, sealed, nil); err != aesgcm.ErrAuthFailed {
+		t.Error(fmt.Sprintf("Expected ErrAuthFailed for modified ciphertext, got %v", err))
+	}
+}
+
+func Test_errors_Seal_nonce(t *testing.T) {
+	defer func() {
+		if recover() != aesgcm.ErrNonceSize {
+			t.Error("Seal did not panic with ErrNonceSize")
+		}
+	}()
+	aesgcm.NewAESGCM(make([]byte, 16)).Seal(nil, make([]byte, 8), nil, nil)
+}
+
+// Every tag byte takes part in the comparison, and a failed Open leaves no bytes behind in dst
+func Test_Open_failure_wipes_dst(t *testing.T) {
+	var nonce = make([]byte, 12)
+	var message = []byte("attack at dawn, attack at dawn, attack at dawn")
+	for _, tagSize := range []int{16, 12, 8, 4} {
+		var testInstance, _ = aesgcm.NewWithSizes(make([]byte, 16), 12, tagSize)
+		var sealed = testInstance.Seal(nil, nonce, message, nil)
+		for position := len(message); position < len(sealed); position++ {
+			sealed[position] ^= 0x01
+			var dst = make([]byte, 3, 3+len(message))
+			for i := range dst[:cap(dst)] {
+				dst[:cap(dst)][i] = 0xff
+			}
+			if opened, err := testInstance.Open(dst, nonce, sealed, nil); err != aesgcm.ErrAuthFailed || opened != nil {
+				t.Error(fmt.Sprintf("Open accepted a %d-byte tag modified at byte %d", tagSize, position-len(message)))
+			}
+			if !bytes.Equal(dst[3:cap(dst)], make([]byte, len(message))) {
+				t.Error(fmt.Sprintf("Open left bytes in dst after failing: %x", dst[3:cap(dst)]))
+			}
+			sealed[position] ^= 0x01
+		}
+	}
+}
+
+// NewCipher against FIPS PUB 197, Appendix C.1-C.3
+func Test_NewCipher_FIPS197(t *testing.T) {
+	aesgcm.WithEachBackend(t, func(t *testing.T) {
+		for keyHex, cipherHex := range map[string]string{
+			"000102030405060708090a0b0c0d0e0f":                                 "69c4e0d86a7b0430d8cdb78070b4c55a",
+			"000102030405060708090a0b0c0d0e0f1011121314151617":                 "dda97ca4864cdfe06eaf70a0ec0d7191",
+			"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f": "8ea2b7ca516745bfeafc49904b496089",
+		} {
+			key, _ := hex.DecodeString(keyHex)
+			pText, _ := hex.DecodeString("00112233445566778899aabbccddeeff")
+			block, err := aesgcm.NewCipher(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var cText = make([]byte, 16)
+			block.Encrypt(cText, pText)
+			assertEqualsString(t, cipherHex, fmt.Sprintf("%x", cText))
+			block.Decrypt(cText, cText) // In place
+			assertEqualsString(t, "00112233445566778899aabbccddeeff", fmt.Sprintf("%x", cText))
+		}
+	})
+}
+
+// The block plugs into crypto/cipher modes and must agree with crypto/aes in both directions
+func Test_NewCipher_CBC(t *testing.T) {
+	aesgcm.WithEachBackend(t, func(t *testing.T) {
+		for iterations := 0; iterations < 200; iterations++ {
+			var key = make([]byte, 16+8*rand.Intn(3))
+			rand.Read(key)
+			var iv = make([]byte, 16)
+			rand.Read(iv)
+			var message = make([]byte, 16*rand.Intn(20))
+			rand.Read(message)
+
+			var goBlock, _ = aes.NewCipher(key)
+			var expected = make([]byte, len(message))
+			cipher.NewCBCEncrypter(goBlock, iv).CryptBlocks(expected, message)
+
+			var block, _ = aesgcm.NewCipher(key)
+			var actual = make([]byte, len(message))
+			cipher.NewCBCEncrypter(block, iv).CryptBlocks(actual, message)
+			if !bytes.Equal(expected, actual) {
+				t.Error(fmt.Sprintf("CBC encrypt fail\nExpected   %x\nActual --> %x\n", expected, actual))
+			}
+			cipher.NewCBCDecrypter(block, iv).CryptBlocks(actual, actual)
+			if !bytes.Equal(message, actual) {
+				t.Error(fmt.Sprintf("CBC decrypt fail\nExpected   %x\nActual --> %x\n", message, actual))
+			}
+		}
+	})
+}
+
+func Test_NewCipher_errors(t *testing.T) {
+	if _, err := aesgcm.NewCipher(make([]byte, 20)); err != aesgcm.ErrKeySize {
+		t.Error(fmt.Sprintf("Expected ErrKeySize, got %v", err))
+	}
+	var block, _ = aesgcm.NewCipher(make([]byte, 16))
+	defer func() {
+		if recover() == nil {
+			t.Error("Decrypt accepted a short block")
+		}
+	}()
+	block.Decrypt(make([]byte, 16), make([]byte, 15))
+}
+
+// GMAC with an empty message - test case 1
+func Test_gmac_empty(t *testing.T) {
+	gmac, _ := aesgcm.NewGMAC(make([]byte, 16), make([]byte, 12))
+	actual := fmt.Sprintf("%x", gmac.Sum(nil))
+	assertEqualsString(t, "58e2fccefa7e3061367f1d57a4e7455a", actual)
+}
+
+// Incremental GMAC must match a one-shot Seal with nil plaintext, however the input is split
+func Test_gmac_incremental(t *testing.T) {
+	aesgcm.WithEachBackend(t, func(t *testing.T) {
+		for iterations := 0; iterations < 200; iterations++ {
+			var key = make([]byte, 16+8*rand.Intn(3))
+			rand.Read(key)
+			var nonce = make([]byte, 1+rand.Intn(20))
+			rand.Read(nonce)
+			var additionalData = make([]byte, rand.Intn(1000))
+			rand.Read(additionalData)
+
+			var expected = aesgcm.NewAESGCMWithNonceSize(key, len(nonce)).Seal(nil, nonce, nil, additionalData)
+			gmac, _ := aesgcm.NewGMAC(key, nonce)
+			for remaining := additionalData; len(remaining) > 0; {
+				var piece = rand.Intn(len(remaining) + 1)
+				_, _ = gmac.Write(remaining[:piece])
+				remaining = remaining[piece:]
+				if !bytes.Equal(gmac.Sum(nil), gmac.Sum(nil)) {
+					t.Error("Sum changed the GMAC state")
+				}
+			}
+			if actual := gmac.Sum([]byte("prefix")); !bytes.Equal(append([]byte("prefix"), expected...), actual) {
+				t.Error(fmt.Sprintf("GMAC fail\nExpected   %x\nActual --> %x\n", expected, actual))
+			}
+
+			gmac.Reset()
+			_, _ = gmac.Write(additionalData)
+			if actual := gmac.Sum(nil); !bytes.Equal(expected, actual) {
+				t.Error(fmt.Sprintf("GMAC after Reset fail\nExpected   %x\nActual --> %x\n", expected, actual))
+			}
+		}
+	})
+}
+
+func Test_gmac_errors(t *testing.T) {
+	if _, err := aesgcm.NewGMAC(make([]byte, 10), make([]byte, 12)); err != aesgcm.ErrKeySize {
+		t.Error(fmt.Sprintf("Expected ErrKeySize, got %v", err))
+	}
+	if _, err := aesgcm.NewGMAC(make([]byte, 16), nil); err != aesgcm.ErrNonceSize {
+		t.Error(fmt.Sprintf("Expected ErrNonceSize, got %v", err))
+	}
+}
+
+// randomPieces splits b at random points, including empty pieces
+func randomPieces(b []byte) [][]byte {
+	var pieces [][]byte
+	for len(b) > 0 {
+		var piece = rand.Intn(min(len(b), 70) + 1)
+		pieces = append(pieces, b[:piece])
+		b = b[piece:]
+	}
+	return pieces
+}
+
+func min(x, y int) int {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+func Test_multipart_matches_Seal(t *testing.T) {
+	aesgcm.WithEachBackend(t, func(t *testing.T) {
+		for iterations := 0; iterations < 200; iterations++ {
+			var key = make([]byte, 16+8*rand.Intn(3))
+			rand.Read(key)
+			var nonce = make([]byte, 1+rand.Intn(20))
+			rand.Read(nonce)
+			var plaintext = make([]byte, rand.Intn(300))
+			rand.Read(plaintext)
+			var additionalData = make([]byte, rand.Intn(100))
+			rand.Read(additionalData)
+			var expected = aesgcm.NewAESGCMWithNonceSize(key, len(nonce)).Seal(nil, nonce, plaintext, additionalData)
+
+			encrypter, _ := aesgcm.NewEncrypter(key, nonce)
+			for _, piece := range randomPieces(additionalData) {
+				_ = encrypter.UpdateAAD(piece)
+			}
+			var actual []byte
+			for _, piece := range randomPieces(plaintext) {
+				var out = make([]byte, len(piece))
+				_ = encrypter.Update(out, piece)
+				actual = append(actual, out...)
+			}
+			actual = append(actual, encrypter.Final()...)
+			if !bytes.Equal(expected, actual) {
+				t.Fatal(fmt.Sprintf("Encrypter fail\nExpected   %x\nActual --> %x\n", expected, actual))
+			}
+
+			decrypter, _ := aesgcm.NewDecrypter(key, nonce)
+			for _, piece := range randomPieces(additionalData) {
+				_ = decrypter.UpdateAAD(piece)
+			}
+			var ciphertext = append([]byte(nil), expected[:len(plaintext)]...)
+			for _, piece := range randomPieces(ciphertext) {
+				_ = decrypter.Update(piece, piece) // In place
+			}
+			if !bytes.Equal(plaintext, ciphertext) {
+				t.Fatal(fmt.Sprintf("Decrypter fail\nExpected   %x\nActual --> %x\n", plaintext, ciphertext))
+			}
+			if err := decrypter.Verify(expected[len(plaintext):]); err != nil {
+				t.Fatal("Verify rejected a valid tag:", err)
+			}
+		}
+	})
+}
+
+func Test_multipart_Verify_tampered(t *testing.T) {
+	var key, nonce = make([]byte, 16), make([]byte, 12)
+	var sealed = aesgcm.NewAESGCM(key).Seal(nil, nonce, []byte("multi-part message"), []byte("header"))
+	sealed[3] ^= 0x01
+	decrypter, _ := aesgcm.NewDecrypter(key, nonce)
+	_ = decrypter.UpdateAAD([]byte("header"))
+	var plaintext = make([]byte, len(sealed)-16)
+	_ = decrypter.Update(plaintext, sealed[:len(sealed)-16])
+	if err := decrypter.Verify(sealed[len(sealed)-16:]); err != aesgcm.ErrAuthFailed {
+		t.Error("Verify accepted tampered ciphertext:", err)
+	}
+	if err := decrypter.Verify(sealed[len(sealed)-16 : len(sealed)-5]); err != aesgcm.ErrTagSize {
+		t.Error("Verify accepted an 11-byte tag:", err)
+	}
+
+	sealed[3] ^= 0x01
+	decrypter, _ = aesgcm.NewDecrypter(key, nonce)
+	_ = decrypter.UpdateAAD([]byte("header"))
+	_ = decrypter.Update(plaintext, sealed[:len(sealed)-16])
+	if err := decrypter.Verify(sealed[len(sealed)-16 : len(sealed)-12]); err != aesgcm.ErrTagSize {
+		t.Error("Verify accepted a 4-byte prefix of a valid tag:", err)
+	}
+	if err := decrypter.Verify(sealed[len(sealed)-16:]); err != nil {
+		t.Error("Verify rejected the full tag after a truncated one:", err)
+	}
+}
+
+func Test_multipart_errors(t *testing.T) {
+	if _, err := aesgcm.NewEncrypter(make([]byte, 15), make([]byte, 12)); err != aesgcm.ErrKeySize {
+		t.Error("NewEncrypter accepted a 15-byte key:", err)
+	}
+	if _, err := aesgcm.NewDecrypter(make([]byte, 16), nil); err != aesgcm.ErrNonceSize {
+		t.Error("NewDecrypter accepted an empty nonce:", err)
+	}
+	encrypter, _ := aesgcm.NewEncrypter(make([]byte, 16), make([]byte, 12))
+	_ = encrypter.Update(make([]byte, 4), make([]byte, 4))
+	if err := encrypter.UpdateAAD([]byte("late")); err != aesgcm.ErrInvalidState {
+		t.Error("UpdateAAD accepted after Update:", err)
+	}
+	var tag = encrypter.Final()
+	if err := encrypter.Update(make([]byte, 4), make([]byte, 4)); err != aesgcm.ErrInvalidState {
+		t.Error("Update accepted after Final:", err)
+	}
+	if !bytes.Equal(tag, encrypter.Final()) {
+		t.Error("Final is not idempotent")
+	}
+}
+
+func sealStream(t *testing.T, key, plaintext []byte, segmentSize int) []byte {
+	var sealed bytes.Buffer
+	writer, err := aesgcm.NewEncryptWriterWithSegmentSize(&sealed, key, segmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, piece := range randomPieces(plaintext) {
+		if _, err := writer.Write(piece); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes()
+}
+
+func openStream(key, sealed []byte) ([]byte, error) {
+	reader, err := aesgcm.NewDecryptReader(bytes.NewReader(sealed), key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+func Test_stream_round_trip(t *testing.T) {
+	var key = make([]byte, 32)
+	rand.Read(key)
+	for _, length := range []int{0, 1, 63, 64, 65, 128, 1000} {
+		var plaintext = make([]byte, length)
+		rand.Read(plaintext)
+		var sealed = sealStream(t, key, plaintext, 64)
+		var segments = (length + 63) / 64 // The final segment is held back, so only an empty stream seals an empty one
+		if segments == 0 {
+			segments = 1
+		}
+		if len(sealed) != 44+length+16*segments {
+			t.Error(fmt.Sprintf("Stream of %d bytes sealed to %d bytes", length, len(sealed)))
+		}
+		actual, err := openStream(key, sealed)
+		if err != nil || !bytes.Equal(plaintext, actual) {
+			t.Error(fmt.Sprintf("Stream of %d bytes failed to open: %v", length, err))
+		}
+	}
+
+	var plaintext = make([]byte, 200000)
+	rand.Read(plaintext)
+	var sealed bytes.Buffer
+	writer, _ := aesgcm.NewEncryptWriter(&sealed, key)
+	_, _ = writer.Write(plaintext)
+	_ = writer.Close()
+	if actual, err := openStream(key, sealed.Bytes()); err != nil || !bytes.Equal(plaintext, actual) {
+		t.Error("Default segment size stream failed to open:", err)
+	}
+}
+
+func Test_stream_tampered(t *testing.T) {
+	var key = make([]byte, 16)
+	var plaintext = make([]byte, 3*64+10)
+	var sealed = sealStream(t, key, plaintext, 64)
+	var segment = func(i int) []byte { // Sealed segments are 80 bytes after the 44-byte header
+		return sealed[44+80*i : min(44+80*(i+1), len(sealed))]
+	}
+	var join = func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	var cases = map[string][]byte{
+		"truncated at segment boundary": sealed[:44+80*3],
+		"truncated mid segment":         sealed[:len(sealed)-1],
+		"reordered":                     join(sealed[:44], segment(1), segment(0), segment(2), segment(3)),
+		"duplicated":                    join(sealed[:44], segment(0), segment(0), segment(1), segment(2), segment(3)),
+		"segment dropped":               join(sealed[:44], segment(0), segment(2), segment(3)),
+		"trailing data":                 join(sealed, []byte{0}),
+		"segment size changed":          join([]byte{1, 0, 0, 0, 65}, sealed[5:]),
+		"salt changed":                  join(sealed[:5], []byte{sealed[5] ^ 1}, sealed[6:]),
+	}
+	for name, stream := range cases {
+		if _, err := openStream(key, stream); err != aesgcm.ErrAuthFailed {
+			t.Error(name, "not detected:", err)
+		}
+	}
+	if _, err := openStream(key, sealed[:5]); err != io.ErrUnexpectedEOF {
+		t.Error("Truncated header not detected:", err)
+	}
+	if _, err := openStream(key, join([]byte{1, 0, 0, 0, 0}, sealed[5:])); err != aesgcm.ErrStreamHeader {
+		t.Error("Zero segment size accepted:", err)
+	}
+	if _, err := openStream(key, join([]byte{2}, sealed[1:])); err != aesgcm.ErrStreamHeader {
+		t.Error("Unknown header version accepted:", err)
+	}
+}
+
+func Test_SegmentReader_ReadAt(t *testing.T) {
+	var key = make([]byte, 16)
+	rand.Read(key)
+	for _, length := range []int{0, 1, 64, 65, 1000} {
+		var plaintext = make([]byte, length)
+		rand.Read(plaintext)
+		var sealed = sealStream(t, key, plaintext, 64)
+		reader, err := aesgcm.NewSegmentReader(bytes.NewReader(sealed), int64(len(sealed)), key)
+		if err != nil || reader.Size() != int64(length) {
+			t.Fatal(fmt.Sprintf("Stream of %d bytes failed to open: %v", length, err))
+		}
+		for iterations := 0; iterations < 100; iterations++ {
+			var off = rand.Intn(length + 1)
+			var p = make([]byte, rand.Intn(200))
+			n, err := reader.ReadAt(p, int64(off))
+			var expected = plaintext[off:min(length, off+len(p))]
+			if !bytes.Equal(expected, p[:n]) || (n < len(p) && err != io.EOF) {
+				t.Fatal(fmt.Sprintf("ReadAt(%d, %d) of %d bytes returned %d, %v", len(p), off, length, n, err))
+			}
+		}
+		if _, err := reader.Seek(int64(length/2), io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if actual, err := ioutil.ReadAll(reader); err != nil || !bytes.Equal(plaintext[length/2:], actual) {
+			t.Error(fmt.Sprintf("Read after Seek of %d bytes failed: %v", length, err))
+		}
+	}
+}
+
+func Test_SegmentReader_tampered(t *testing.T) {
+	var key = make([]byte, 16)
+	var plaintext = make([]byte, 3*64+10)
+	var sealed = sealStream(t, key, plaintext, 64)
+	for name, stream := range map[string][]byte{
+		"truncated at segment boundary": sealed[:44+80*3],
+		"extended":                      append(append([]byte(nil), sealed...), 0),
+	} {
+		if _, err := aesgcm.NewSegmentReader(bytes.NewReader(stream), int64(len(stream)), key); err != aesgcm.ErrAuthFailed {
+			t.Error(name, "not detected:", err)
+		}
+	}
+
+	sealed[44+80+5] ^= 0x01 // Second segment
+	reader, err := aesgcm.NewSegmentReader(bytes.NewReader(sealed), int64(len(sealed)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p = make([]byte, 64)
+	if n, err := reader.ReadAt(p, 128); n != 64 || err != nil {
+		t.Error("Untouched third segment failed to read:", n, err)
+	}
+	if _, err := reader.ReadAt(p, 100); err != aesgcm.ErrAuthFailed {
+		t.Error("Tampered second segment not detected:", err)
+	}
+}
+
+// ReadAt shares pooled buffers between calls, which must never share one at the same time
+func Test_SegmentReader_ReadAt_concurrent(t *testing.T) {
+	var key = make([]byte, 16)
+	var plaintext = make([]byte, 1000)
+	rand.Read(plaintext)
+	var sealed = sealStream(t, key, plaintext, 64)
+	reader, err := aesgcm.NewSegmentReader(bytes.NewReader(sealed), int64(len(sealed)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for goroutine := 0; goroutine < 8; goroutine++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			var random = rand.New(rand.NewSource(seed))
+			for iterations := 0; iterations < 200; iterations++ {
+				var off = random.Intn(len(plaintext))
+				var p = make([]byte, random.Intn(150))
+				n, _ := reader.ReadAt(p, int64(off))
+				if !bytes.Equal(plaintext[off:off+n], p[:n]) {
+					t.Error(fmt.Sprintf("Concurrent ReadAt(%d, %d) returned wrong plaintext", len(p), off))
+					return
+				}
+			}
+		}(int64(goroutine))
+	}
+	wg.Wait()
+}
+
+// Streams under one key have their own derived keys, so segments cannot be moved between them
+func Test_SegmentReader_spliced(t *testing.T) {
+	var key = make([]byte, 16)
+	rand.Read(key)
+	var first, second = make([]byte, 3*64+10), make([]byte, 3*64+10)
+	rand.Read(first)
+	rand.Read(second)
+	var sealedFirst, sealedSecond = sealStream(t, key, first, 64), sealStream(t, key, second, 64)
+	for i, sealed := range [][]byte{sealedFirst, sealedSecond} {
+		reader, err := aesgcm.NewSegmentReader(bytes.NewReader(sealed), int64(len(sealed)), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual, err := ioutil.ReadAll(reader); err != nil || !bytes.Equal([][]byte{first, second}[i], actual) {
+			t.Error("Stream", i, "failed to open:", err)
+		}
+	}
+
+	var join = func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	var headerSpliced = join(sealedFirst[:44], sealedSecond[44:])
+	if _, err := aesgcm.NewSegmentReader(bytes.NewReader(headerSpliced), int64(len(headerSpliced)), key); err != aesgcm.ErrAuthFailed {
+		t.Error("Header of another stream not detected:", err)
+	}
+	if _, err := openStream(key, headerSpliced); err != aesgcm.ErrAuthFailed {
+		t.Error("Header of another stream not detected by NewDecryptReader:", err)
+	}
+	var segmentSpliced = join(sealedFirst[:44+80], sealedSecond[44+80:44+160], sealedFirst[44+160:])
+	reader, err := aesgcm.NewSegmentReader(bytes.NewReader(segmentSpliced), int64(len(segmentSpliced)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p = make([]byte, 64)
+	if _, err := reader.ReadAt(p, 64); err != aesgcm.ErrAuthFailed {
+		t.Error("Segment of another stream not detected:", err)
+	}
+	if _, err := openStream(key, segmentSpliced); err != aesgcm.ErrAuthFailed {
+		t.Error("Segment of another stream not detected by NewDecryptReader:", err)
+	}
+}
+
+func Test_gcmsiv_RFC8452(t *testing.T) { // Appendix C
+	var vectors = []struct{ key, nonce, plaintext, additionalData, result string }{
+		{"01000000000000000000000000000000", "030000000000000000000000", "", "",
+			"dc20e2d83f25705bb49e439eca56de25"},
+		{"01000000000000000000000000000000", "030000000000000000000000", "0100000000000000", "",
+			"b5d839330ac7b786578782fff6013b815b287c22493a364c"},
+		{"01000000000000000000000000000000", "030000000000000000000000", "010000000000000000000000", "",
+			"7323ea61d05932260047d942a4978db357391a0bc4fdec8b0d106639"},
+		{"01000000000000000000000000000000", "030000000000000000000000", "01000000000000000000000000000000", "",
+			"743f7c8077ab25f8624e2e948579cf77303aaf90f6fe21199c6068577437a0c4"},
+		// C.1 with additional data, a whole block or less and then over a block boundary
+		{"01000000000000000000000000000000", "030000000000000000000000", "0200000000000000", "01",
+			"1e6daba35669f4273b0a1a2560969cdf790d99759abd1508"},
+		{"01000000000000000000000000000000", "030000000000000000000000", "02000000000000000000000000000000", "01",
+			"e2b0c5da79a901c1745f700525cb335b8f8936ec039e4e4bb97ebd8c4457441f"},
+		{"01000000000000000000000000000000", "030000000000000000000000", "02000000", "010000000000000000000000",
+			"a8fe3e8707eb1f84fb28f8cb73de8e99e2f48a14"},
+		{"01000000000000000000000000000000", "030000000000000000000000", "0300000000000000000000000000000004000000", "010000000000000000000000000000000200",
+			"6bb0fecf5ded9b77f902c7d5da236a4391dd029724afc9805e976f451e6d87f6fe106514"},
+		{"01000000000000000000000000000000", "030000000000000000000000", "030000000000000000000000000000000400", "0100000000000000000000000000000002000000",
+			"44d0aaf6fb2f1f34add5e8064e83e12a2adabff9b2ef00fb47920cc72a0c0f13b9fd"},
+		{"0100000000000000000000000000000000000000000000000000000000000000", "030000000000000000000000", "", "",
+			"07f5f4169bbf55a8400cd47ea6fd400f"},
+		{"0100000000000000000000000000000000000000000000000000000000000000", "030000000000000000000000", "0100000000000000", "",
+			"c2ef328e5c71c83b843122130f7364b761e0b97427e3df28"},
+		{"0100000000000000000000000000000000000000000000000000000000000000", "030000000000000000000000", "01000000000000000000000000000000", "",
+			"85a01b63025ba19b7fd3ddfc033b3e76c9eac6fa700942702e90862383c6c366"},
+		// C.2 with additional data
+		{"0100000000000000000000000000000000000000000000000000000000000000", "030000000000000000000000", "0200000000000000", "01",
+			"1de22967237a813291213f267e3b452f02d01ae33e4ec854"},
+		{"0100000000000000000000000000000000000000000000000000000000000000", "030000000000000000000000", "02000000", "010000000000000000000000",
+			"22b3f4cd1835e517741dfddccfa07fa4661b74cf"},
+		{"0100000000000000000000000000000000000000000000000000000000000000", "030000000000000000000000", "0300000000000000000000000000000004000000", "010000000000000000000000000000000200",
+			"43dd0163cdb48f9fe3212bf61b201976067f342bb879ad976d8242acc188ab59cabfe307"},
+		{"0100000000000000000000000000000000000000000000000000000000000000", "030000000000000000000000", "030000000000000000000000000000000400", "0100000000000000000000000000000002000000",
+			"462401724b5ce6588d5a54aae5375513a075cfcdf5042112aa29685c912fc2056543"},
+		// Counter wrap, Appendix C.3
+		{"0000000000000000000000000000000000000000000000000000000000000000", "000000000000000000000000",
+			"000000000000000000000000000000004db923dc793ee6497c76dcc03a98e108", "",
+			"f3f80f2cf0cb2dd9c5984fcda908456cc537703b5ba70324a6793a7bf218d3eaffffffff000000000000000000000000"},
+		{"0000000000000000000000000000000000000000000000000000000000000000", "000000000000000000000000",
+			"eb3640277c7ffd1303c7a542d02d3e4c0000000000000000", "",
+			"18ce4f0b8cb4d0cac65fea8f79257b20888e53e72299e56dffffffff000000000000000000000000"},
+	}
+	aesgcm.WithEachBackend(t, func(t *testing.T) {
+		for _, vector := range vectors {
+			key, _ := hex.DecodeString(vector.key)
+			nonce, _ := hex.DecodeString(vector.nonce)
+			plaintext, _ := hex.DecodeString(vector.plaintext)
+			additionalData, _ := hex.DecodeString(vector.additionalData)
+			aead, err := aesgcm.NewAESGCMSIV(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sealed = aead.Seal(nil, nonce, plaintext, additionalData)
+			assertEqualsString(t, vector.result, hex.EncodeToString(sealed))
+			opened, err := aead.Open(nil, nonce, sealed, additionalData)
+			if err != nil || !bytes.Equal(plaintext, opened) {
+				t.Error("Open failed:", err)
+			}
+			sealed[0] ^= 0x01
+			if _, err := aead.Open(nil, nonce, sealed, additionalData); err != aesgcm.ErrAuthFailed {
+				t.Error("Open accepted tampered ciphertext:", err)
+			}
+		}
+	})
+	if _, err := aesgcm.NewAESGCMSIV(make([]byte, 24)); err != aesgcm.ErrKeySize {
+		t.Error("NewAESGCMSIV accepted a 24-byte key:", err)
+	}
+}
+
+func Test_ccm_SP800_38C(t *testing.T) { // Appendix C examples
+	var longAdditionalData = make([]byte, 1<<16)
+	for i := range longAdditionalData {
+		longAdditionalData[i] = byte(i)
+	}
+	var vectors = []struct {
+		nonce, additionalData, plaintext string
+		tagSize                          int
+		result                           string
+	}{
+		{"10111213141516", "0001020304050607", "20212223", 4, "7162015b4dac255d"},
+		{"1011121314151617", "000102030405060708090a0b0c0d0e0f", "202122232425262728292a2b2c2d2e2f", 6,
+			"d2a1f0e051ea5f62081a7792073d593d1fc64fbfaccd"},
+		{"101112131415161718191a1b", "000102030405060708090a0b0c0d0e0f10111213",
+			"202122232425262728292a2b2c2d2e2f3031323334353637", 8,
+			"e3b201a9f5b71a7a9b1ceaeccd97e70b6176aad9a4428aa5484392fbc1b09951"},
+		{"101112131415161718191a1b1c", hex.EncodeToString(longAdditionalData),
+			"202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f", 14,
+			"69915dad1e84c6376a68c2967e4dab615ae0fd1faec44cc484828529463ccf72b4ac6bec93e8598e7f0dadbcea5b"},
+	}
+	var key, _ = hex.DecodeString("404142434445464748494a4b4c4d4e4f")
+	aesgcm.WithEachBackend(t, func(t *testing.T) {
+		for _, vector := range vectors {
+			nonce, _ := hex.DecodeString(vector.nonce)
+			additionalData, _ := hex.DecodeString(vector.additionalData)
+			plaintext, _ := hex.DecodeString(vector.plaintext)
+			aead, err := aesgcm.NewAESCCM(key, len(nonce), vector.tagSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sealed = aead.Seal(nil, nonce, plaintext, additionalData)
+			assertEqualsString(t, vector.result, hex.EncodeToString(sealed))
+			opened, err := aead.Open(sealed[:0], nonce, sealed, additionalData) // In place
+			if err != nil || !bytes.Equal(plaintext, opened) {
+				t.Error("Open failed:", err)
+			}
+		}
+	})
+}
+
+func Test_ccm_errors(t *testing.T) {
+	var key = make([]byte, 16)
+	for _, sizes := range [][2]int{{6, 16}, {14, 16}, {13, 3}, {13, 5}, {13, 18}} {
+		if _, err := aesgcm.NewAESCCM(key, sizes[0], sizes[1]); err == nil {
+			t.Error("NewAESCCM accepted nonce and tag sizes", sizes)
+		}
+	}
+	aead, _ := aesgcm.NewAESCCM(key, 13, 8) // Two-byte length field
+	func() {
+		defer func() {
+			if recover() != aesgcm.ErrMessageTooLarge {
+				t.Error("Seal accepted a payload over 2^16 - 1 bytes")
+			}
+		}()
+		aead.Seal(nil, make([]byte, 13), make([]byte, 1<<16), nil)
+	}()
+	var sealed = aead.Seal(nil, make([]byte, 13), []byte("payload"), nil)
+	sealed[len(sealed)-1] ^= 0x01
+	if _, err := aead.Open(nil, make([]byte, 13), sealed, nil); err != aesgcm.ErrAuthFailed {
+		t.Error("Open accepted a tampered tag:", err)
+	}
+}
+
+func Test_keyWrap_RFC3394(t *testing.T) { // Section 4
+	var vectors = []struct{ kek, key, wrapped string }{
+		{"000102030405060708090a0b0c0d0e0f", "00112233445566778899aabbccddeeff",
+			"1fa68b0a8112b447aef34bd8fb5a7b829d3e862371d2cfe5"},
+		{"000102030405060708090a0b0c0d0e0f1011121314151617", "00112233445566778899aabbccddeeff",
+			"96778b25ae6ca435f92b5b97c050aed2468ab8a17ad84e5d"},
+		{"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f", "00112233445566778899aabbccddeeff",
+			"64e8c3f9ce0f5ba263e9777905818a2a93c8191e7d6e8ae7"},
+		{"000102030405060708090a0b0c0d0e0f1011121314151617", "00112233445566778899aabbccddeeff0001020304050607",
+			"031d33264e15d33268f24ec260743edce1c6c7ddee725a936ba814915c6762d2"},
+		{"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f", "00112233445566778899aabbccddeeff0001020304050607",
+			"a8f9bc1612c68b3ff6e6f4fbe30e71e4769c8b80a32cb8958cd5d17d6b254da1"},
+		{"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+			"00112233445566778899aabbccddeeff000102030405060708090a0b0c0d0e0f",
+			"28c9f404c4b810f4cbccb35cfb87f8263f5786e2d80ed326cbc7f0e71a99f43bfb988b9b7a02dd21"},
+	}
+	aesgcm.WithEachBackend(t, func(t *testing.T) {
+		for _, vector := range vectors {
+			kek, _ := hex.DecodeString(vector.kek)
+			key, _ := hex.DecodeString(vector.key)
+			wrapped, err := aesgcm.Wrap(kek, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertEqualsString(t, vector.wrapped, hex.EncodeToString(wrapped))
+			unwrapped, err := aesgcm.Unwrap(kek, wrapped)
+			if err != nil || !bytes.Equal(key, unwrapped) {
+				t.Error("Unwrap failed:", err)
+			}
+			wrapped[len(wrapped)-1] ^= 0x01
+			if _, err := aesgcm.Unwrap(kek, wrapped); err != aesgcm.ErrAuthFailed {
+				t.Error("Unwrap accepted a modified key:", err)
+			}
+		}
+	})
+}
+
+func Test_keyWrap_RFC5649(t *testing.T) { // Section 6
+	var kek, _ = hex.DecodeString("5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8")
+	var vectors = []struct{ key, wrapped string }{
+		{"c37b7e6492584340bed12207808941155068f738", "138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a"},
+		{"466f7250617369", "afbeb0f07dfbf5419200f2ccb50bb24f"},
+	}
+	aesgcm.WithEachBackend(t, func(t *testing.T) {
+		for _, vector := range vectors {
+			key, _ := hex.DecodeString(vector.key)
+			wrapped, err := aesgcm.WrapPad(kek, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertEqualsString(t, vector.wrapped, hex.EncodeToString(wrapped))
+			unwrapped, err := aesgcm.UnwrapPad(kek, wrapped)
+			if err != nil || !bytes.Equal(key, unwrapped) {
+				t.Error("UnwrapPad failed:", err)
+			}
+			wrapped[0] ^= 0x01
+			if _, err := aesgcm.UnwrapPad(kek, wrapped); err != aesgcm.ErrAuthFailed {
+				t.Error("UnwrapPad accepted a modified key:", err)
+			}
+		}
+	})
+}
+
+func Test_keyWrap_errors(t *testing.T) {
+	var kek = make([]byte, 16)
+	if _, err := aesgcm.Wrap(kek, make([]byte, 8)); err != aesgcm.ErrWrapSize {
+		t.Error("Wrap accepted a single semiblock:", err)
+	}
+	if _, err := aesgcm.Wrap(kek, make([]byte, 20)); err != aesgcm.ErrWrapSize {
+		t.Error("Wrap accepted a partial semiblock:", err)
+	}
+	if _, err := aesgcm.WrapPad(kek, nil); err != aesgcm.ErrWrapSize {
+		t.Error("WrapPad accepted an empty key:", err)
+	}
+	if _, err := aesgcm.Unwrap(make([]byte, 15), make([]byte, 24)); err != aesgcm.ErrKeySize {
+		t.Error("Unwrap accepted a 15-byte KEK:", err)
+	}
+	if _, err := aesgcm.UnwrapPad(kek, make([]byte, 20)); err != aesgcm.ErrWrapSize {
+		t.Error("UnwrapPad accepted a partial semiblock:", err)
+	}
+	// KW output is not KWP output, even for a multiple of 8 bytes, as the integrity values differ
+	wrapped, _ := aesgcm.Wrap(kek, make([]byte, 16))
+	if _, err := aesgcm.UnwrapPad(kek, wrapped); err != aesgcm.ErrAuthFailed {
+		t.Error("UnwrapPad accepted KW output:", err)
+	}
+}
+
+func Test_cmac_RFC4493(t *testing.T) { // RFC 4493 section 4, and SP 800-38B Appendix D for 192 and 256-bit keys
+	var message, _ = hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e51" +
+		"30c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+	var vectors = []struct {
+		key  string
+		macs [4]string // Of the first 0, 16, 40 and 64 bytes of message
+	}{
+		{"2b7e151628aed2a6abf7158809cf4f3c", [4]string{"bb1d6929e95937287fa37d129b756746",
+			"070a16b46b4d4144f79bdd9dd04a287c", "dfa66747de9ae63030ca32611497c827", "51f0bebf7e3b9d92fc49741779363cfe"}},
+		{"8e73b0f7da0e6452c810f32b809079e562f8ead2522c6b7b", [4]string{"d17ddf46adaacde531cac483de7a9367",
+			"9e99a7bf31e710900662f65e617c5184", "8a1de5be2eb31aad089a82e6ee908b0e", "a1d5df0eed790f794d77589659f39a11"}},
+		{"603deb1015ca71be2b73aef0857d77811f352c073b6108d72d9810a30914dff4", [4]string{"028962f61b7bf89efc6b551f4667d983",
+			"28a7023f452e8f82bd4bf28d8c37c35c", "aaf3d8f1de5640c232f5b169b9c911e6", "e1992190549f6ed5696a2c056c315410"}},
+	}
+	aesgcm.WithEachBackend(t, func(t *testing.T) {
+		for _, vector := range vectors {
+			key, _ := hex.DecodeString(vector.key)
+			cmac, err := aesgcm.NewCMAC(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, length := range []int{0, 16, 40, 64} {
+				cmac.Reset()
+				for _, piece := range randomPieces(message[:length]) {
+					_, _ = cmac.Write(piece)
+				}
+				assertEqualsString(t, vector.macs[i], hex.EncodeToString(cmac.Sum(nil)))
+			}
+		}
+	})
+	if _, err := aesgcm.NewCMAC(make([]byte, 20)); err != aesgcm.ErrKeySize {
+		t.Error("NewCMAC accepted a 20-byte key:", err)
+	}
+}
+
+func Test_siv_RFC5297(t *testing.T) { // Appendix A
+	var vectors = []struct {
+		key            string
+		additionalData []string
+		plaintext      string
+		result         string
+	}{
+		{"fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff",
+			[]string{"101112131415161718191a1b1c1d1e1f2021222324252627"},
+			"112233445566778899aabbccddee",
+			"85632d07c6e8f37f950acd320a2ecc9340c02b9690c4dc04daef7f6afe5c"},
+		{"7f7e7d7c7b7a79787776757473727170404142434445464748494a4b4c4d4e4f",
+			[]string{"00112233445566778899aabbccddeeffdeaddadadeaddadaffeeddccbbaa99887766554433221100",
+				"102030405060708090a0", "09f911029d74e35bd84156c5635688c0"},
+			"7468697320697320736f6d6520706c61696e7465787420746f20656e6372797074207573696e67205349562d414553",
+			"7bdb6e3b432667eb06f4d14bff2fbd0fcb900f2fddbe404326601965c889bf17dba77ceb094fa663b7a3f748ba8af829" +
+				"ea64ad544a272e9c485b62a3fd5c0d"},
+	}
+	aesgcm.WithEachBackend(t, func(t *testing.T) {
+		for _, vector := range vectors {
+			key, _ := hex.DecodeString(vector.key)
+			plaintext, _ := hex.DecodeString(vector.plaintext)
+			var additionalData [][]byte
+			for _, ad := range vector.additionalData {
+				decoded, _ := hex.DecodeString(ad)
+				additionalData = append(additionalData, decoded)
+			}
+			siv, err := aesgcm.NewAESSIV(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sealed = siv.Seal(nil, additionalData, plaintext)
+			assertEqualsString(t, vector.result, hex.EncodeToString(sealed))
+			opened, err := siv.Open(nil, additionalData, sealed)
+			if err != nil || !bytes.Equal(plaintext, opened) {
+				t.Error("Open failed:", err)
+			}
+			if _, err := siv.Open(nil, additionalData[:len(additionalData)-1], sealed); err != aesgcm.ErrAuthFailed {
+				t.Error("Open accepted a missing additional data string:", err)
+			}
+		}
+	})
+}
+
+func Test_siv_round_trip(t *testing.T) {
+	aesgcm.WithEachBackend(t, func(t *testing.T) {
+		for iterations := 0; iterations < 100; iterations++ {
+			var key = make([]byte, 32+16*rand.Intn(3))
+			rand.Read(key)
+			var plaintext = make([]byte, rand.Intn(100))
+			rand.Read(plaintext)
+			var additionalData = make([][]byte, rand.Intn(4))
+			for i := range additionalData {
+				additionalData[i] = make([]byte, rand.Intn(40))
+				rand.Read(additionalData[i])
+			}
+			siv, _ := aesgcm.NewAESSIV(key)
+			var sealed = siv.Seal(nil, additionalData, plaintext)
+			if !bytes.Equal(sealed, siv.Seal(nil, additionalData, plaintext)) {
+				t.Fatal("Seal is not deterministic")
+			}
+			opened, err := siv.Open(sealed[16:16], additionalData, sealed) // In place
+			if err != nil || !bytes.Equal(plaintext, opened) {
+				t.Fatal("Open failed:", err)
+			}
+		}
+	})
+	if _, err := aesgcm.NewAESSIV(make([]byte, 16)); err != aesgcm.ErrKeySize {
+		t.Error("NewAESSIV accepted a 128-bit key:", err)
+	}
+	siv, _ := aesgcm.NewAESSIV(make([]byte, 32))
+	if _, err := siv.Open(nil, make([][]byte, 127), make([]byte, 16)); err != aesgcm.ErrSIVAdditionalData {
+		t.Error("Open accepted 127 additional data strings:", err)
+	}
+}
+
+func Test_xts_IEEE1619(t *testing.T) { // Annex B vectors 1-3 and 15-18
+	var vectors = []struct {
+		key        string
+		sectorNum  uint64
+		plaintext  string
+		ciphertext string
+	}{
+		{"0000000000000000000000000000000000000000000000000000000000000000", 0,
+			"0000000000000000000000000000000000000000000000000000000000000000",
+			"917cf69ebd68b2ec9b9fe9a3eadda692cd43d2f59598ed858c02c2652fbf922e"},
+		{"1111111111111111111111111111111122222222222222222222222222222222", 0x3333333333,
+			"4444444444444444444444444444444444444444444444444444444444444444",
+			"c454185e6a16936e39334038acef838bfb186fff7480adc4289382ecd6d394f0"},
+		{"fffefdfcfbfaf9f8f7f6f5f4f3f2f1f022222222222222222222222222222222", 0x3333333333,
+			"4444444444444444444444444444444444444444444444444444444444444444",
+			"af85336b597afc1a900b2eb21ec949d292df4c047e0b21532186a5971a227a89"},
+		{"fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0bfbebdbcbbbab9b8b7b6b5b4b3b2b1b0", 0x123456789a,
+			"000102030405060708090a0b0c0d0e0f10", "6c1625db4671522d3d7599601de7ca09ed"},
+		{"fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0bfbebdbcbbbab9b8b7b6b5b4b3b2b1b0", 0x123456789a,
+			"000102030405060708090a0b0c0d0e0f1011", "d069444b7a7e0cab09e24447d24deb1fedbf"},
+		{"fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0bfbebdbcbbbab9b8b7b6b5b4b3b2b1b0", 0x123456789a,
+			"000102030405060708090a0b0c0d0e0f101112", "e5df1351c0544ba1350b3363cd8ef4beedbf9d"},
+		{"fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0bfbebdbcbbbab9b8b7b6b5b4b3b2b1b0", 0x123456789a,
+			"000102030405060708090a0b0c0d0e0f10111213", "9d84c813f719aa2c7be3f66171c7c5c2edbf9dac"},
+	}
+	aesgcm.WithEachBackend(t, func(t *testing.T) {
+		for _, vector := range vectors {
+			key, _ := hex.DecodeString(vector.key)
+			plaintext, _ := hex.DecodeString(vector.plaintext)
+			xts, err := aesgcm.NewXTS(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sector = make([]byte, len(plaintext))
+			xts.EncryptSector(sector, plaintext, vector.sectorNum)
+			assertEqualsString(t, vector.ciphertext, hex.EncodeToString(sector))
+			xts.DecryptSector(sector, sector, vector.sectorNum) // In place
+			assertEqualsString(t, vector.plaintext, hex.EncodeToString(sector))
+		}
+	})
+}
+
+func Test_xts_round_trip(t *testing.T) {
+	aesgcm.WithEachBackend(t, func(t *testing.T) {
+		for iterations := 0; iterations < 100; iterations++ {
+			var key = make([]byte, 32+32*rand.Intn(2))
+			rand.Read(key)
+			var plaintext = make([]byte, 16+rand.Intn(200))
+			rand.Read(plaintext)
+			var sectorNum = rand.Uint64()
+			xts, _ := aesgcm.NewXTS(key)
+			var ciphertext, decrypted = make([]byte, len(plaintext)), make([]byte, len(plaintext))
+			xts.EncryptSector(ciphertext, plaintext, sectorNum)
+			xts.DecryptSector(decrypted, ciphertext, sectorNum)
+			if !bytes.Equal(plaintext, decrypted) {
+				t.Fatal(fmt.Sprintf("Round trip of %d bytes failed", len(plaintext)))
+			}
+		}
+	})
+	if _, err := aesgcm.NewXTS(make([]byte, 48)); err != aesgcm.ErrKeySize {
+		t.Error("NewXTS accepted a 384-bit key:", err)
+	}
+	xts, _ := aesgcm.NewXTS(make([]byte, 32))
+	defer func() {
+		if recover() == nil {
+			t.Error("EncryptSector accepted a 15-byte sector")
+		}
+	}()
+	xts.EncryptSector(make([]byte, 15), make([]byte, 15), 0)
+}
+
+func Test_NonceSequence(t *testing.T) {
+	seq, _ := aesgcm.NewNonceSequence([]byte{0xde, 0xad, 0xbe, 0xef})
+	var seen = make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		nonce, err := seq.Next()
+		if err != nil || seen[string(nonce)] || !bytes.HasPrefix(nonce, []byte{0xde, 0xad, 0xbe, 0xef}) {
+			t.Fatal("Bad nonce", hex.EncodeToString(nonce), err)
+		}
+		seen[string(nonce)] = true
+	}
+	assertEqualsString(t, "deadbeef00000000000003e8", hex.EncodeToString(mustNext(seq)))
+
+	var state, _ = seq.MarshalBinary() // A restart resumes after the last nonce handed out
+	var restored = new(aesgcm.NonceSequence)
+	if err := restored.UnmarshalBinary(state); err != nil {
+		t.Fatal(err)
+	}
+	assertEqualsString(t, "deadbeef00000000000003e9", hex.EncodeToString(mustNext(restored)))
+	if err := restored.UnmarshalBinary(state[:11]); err != aesgcm.ErrNonceSize {
+		t.Error("UnmarshalBinary accepted a short state:", err)
+	}
+}
+
+func mustNext(seq *aesgcm.NonceSequence) []byte {
+	nonce, err := seq.Next()
+	if err != nil {
+		panic(err)
+	}
+	return nonce
+}
+
+func Test_NonceSequence_exhausted(t *testing.T) {
+	seq, _ := aesgcm.NewNonceSequence(make([]byte, 8)) // 32-bit counter
+	var state, _ = seq.MarshalBinary()
+	copy(state[8:], []byte{0, 0, 0, 0, 0xff, 0xff, 0xff, 0xfe})
+	_ = seq.UnmarshalBinary(state)
+	var aead = aesgcm.NewAESGCM(make([]byte, 16))
+	for _, expected := range []string{"0000000000000000fffffffe", "0000000000000000ffffffff"} {
+		sealed, err := seq.SealNext(aead, []byte("prefix"), []byte("plaintext"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqualsString(t, expected, hex.EncodeToString(sealed[6:18]))
+		if opened, err := aead.Open(nil, sealed[6:18], sealed[18:], nil); err != nil || string(opened) != "plaintext" {
+			t.Error("SealNext output failed to open:", err)
+		}
+	}
+	if _, err := seq.SealNext(aead, nil, []byte("plaintext"), nil); err != aesgcm.ErrNonceExhausted {
+		t.Error("SealNext wrapped the counter:", err)
+	}
+	state, _ = seq.MarshalBinary()
+	_ = seq.UnmarshalBinary(state)
+	if _, err := seq.Next(); err != aesgcm.ErrNonceExhausted {
+		t.Error("Exhaustion lost across MarshalBinary:", err)
+	}
+	if _, err := seq.SealNext(aesgcm.NewAESGCMWithNonceSize(make([]byte, 16), 16), nil, nil, nil); err != aesgcm.ErrNonceSize {
+		t.Error("SealNext accepted a 16-byte nonce AEAD:", err)
+	}
+}
+
+func Test_RandomSealer(t *testing.T) {
+	sealer, err := aesgcm.NewRandomSealer(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := sealer.SealRandom([]byte("prefix"), []byte("blob"), []byte("header"))
+	second, _ := sealer.SealRandom(nil, []byte("blob"), []byte("header"))
+	if len(second) != 4+sealer.Overhead() || bytes.Equal(first[6:18], second[:12]) {
+		t.Error("SealRandom output malformed or nonce repeated")
+	}
+	if opened, err := sealer.OpenRandom(nil, first[6:], []byte("header")); err != nil || string(opened) != "blob" {
+		t.Error("OpenRandom failed:", err)
+	}
+	if _, err := sealer.OpenRandom(nil, second, []byte("other header")); err != aesgcm.ErrAuthFailed {
+		t.Error("OpenRandom accepted the wrong header:", err)
+	}
+	if _, err := sealer.OpenRandom(nil, second[:27], nil); err != aesgcm.ErrAuthFailed {
+		t.Error("OpenRandom accepted truncated input:", err)
+	}
+}
@@ -0,0 +1,105 @@
+package aesgcm
+
+import (
+	"hash"
+)
+
+// CMAC per NIST SP 800-38B (RFC 4493): CBC-MAC whose last block is masked by subkey K1 when complete, or
+// padded with 10* and masked by K2 otherwise, so the last block must be held back until Sum
+type cmac struct {
+	aesCipher
+	k1, k2   [16]byte
+	x        [16]byte // Chaining value over the blocks before the buffer
+	buffer   [16]byte // Last block seen, complete or not
+	buffered int
+}
+
+// NewCMAC returns a hash.Hash computing the 16-byte AES-CMAC of everything written to it, or ErrKeySize.
+// Truncate the result of Sum for shorter MACs, and compare MACs with subtle.ConstantTimeCompare.
+func NewCMAC(key []byte) (hash.Hash, error) {
+	if (len(key) != 16) && (len(key) != 24) && (len(key) != 32) {
+		return nil, ErrKeySize
+	}
+	var cmac = new(cmac)
+	cmac.asm = useAsm
+	cmac.expandAesKey(key)
+	var l [16]byte // Subkeys per SP 800-38B section 6.1
+	cmac.encryptBlocks(l[:], l[:])
+	cmac.k1 = double(l)
+	cmac.k2 = double(cmac.k1)
+	zero(l[:])
+	return cmac, nil
+}
+
+// double multiplies by x in GF(2^128) with the polynomial x^128 + x^7 + x^2 + x + 1, in constant time
+func double(in [16]byte) [16]byte {
+	var out [16]byte
+	var msb = in[0] >> 7
+	for i := 0; i < 15; i++ {
+		out[i] = in[i]<<1 | in[i+1]>>7
+	}
+	out[15] = in[15]<<1 ^ (0x87 & -msb)
+	return out
+}
+
+// Destroy wipes the key schedule, the subkeys and the chaining state; the returned hash.Hash implements
+// Destroyer. Later writes return ErrDestroyed and Sum panics with it.
+func (cmac *cmac) Destroy() {
+	cmac.aesCipher.destroy()
+	zero(cmac.k1[:])
+	zero(cmac.k2[:])
+	cmac.Reset()
+}
+
+func (cmac *cmac) Write(p []byte) (int, error) {
+	if cmac.destroyed {
+		return 0, ErrDestroyed
+	}
+	var written = len(p)
+	for len(p) > 0 {
+		if cmac.buffered == 16 { // More input follows, so the buffer is not the last block
+			for i := range cmac.x {
+				cmac.x[i] ^= cmac.buffer[i]
+			}
+			cmac.encryptBlocks(cmac.x[:], cmac.x[:])
+			cmac.buffered = 0
+		}
+		var n = copy(cmac.buffer[cmac.buffered:], p)
+		cmac.buffered += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Sum appends the MAC to b without changing the state, so more input may still be written
+func (cmac *cmac) Sum(b []byte) []byte {
+	if cmac.destroyed {
+		panic(ErrDestroyed)
+	}
+	var last = cmac.buffer
+	var subkey = &cmac.k1
+	if cmac.buffered < 16 {
+		last[cmac.buffered] = 0x80
+		zero(last[cmac.buffered+1:])
+		subkey = &cmac.k2
+	}
+	for i := range last {
+		last[i] ^= cmac.x[i] ^ subkey[i]
+	}
+	cmac.encryptBlocks(last[:], last[:])
+	return append(b, last[:]...)
+}
+
+func (cmac *cmac) Reset() {
+	zero(cmac.x[:])
+	zero(cmac.buffer[:])
+	cmac.buffered = 0
+}
+
+func (cmac *cmac) Size() int {
+	return 16
+}
+
+func (cmac *cmac) BlockSize() int {
+	return 16
+}
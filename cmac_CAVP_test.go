@@ -0,0 +1,125 @@
+package aesgcm_test
+
+import (
+	"aesgcm"
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"testing"
+)
+
+var testCMACGenFiles = []string{
+	"./cmactestvectors/CMACGenAES128.rsp", "./cmactestvectors/CMACGenAES192.rsp", "./cmactestvectors/CMACGenAES256.rsp",
+}
+
+var testCMACVerFiles = []string{
+	"./cmactestvectors/CMACVerAES128.rsp", "./cmactestvectors/CMACVerAES192.rsp", "./cmactestvectors/CMACVerAES256.rsp",
+}
+
+func Test_cmac_generation(t *testing.T) {
+	aesgcm.WithEachBackend(t, cmacGenerationVectors)
+}
+
+func cmacGenerationVectors(t *testing.T) {
+	var Count, Klen, Mlen, Tlen int // CAVP test fields
+	var Key, Msg, Mac []byte        // CAVP test fields
+
+	for _, fileName := range testCMACGenFiles {
+		var lineNumber int
+
+		fileHandle, err := os.Open(fileName)
+		if err != nil {
+			t.Error(fmt.Sprintf("Unable to open file: %v", fileName))
+			return
+		}
+
+		fileScanner := bufio.NewScanner(fileHandle)
+		for fileScanner.Scan() {
+			line := fileScanner.Text()
+			lineNumber++
+			// Slightly inefficient but effective
+			_, _ = fmt.Sscanf(line, "Count = %d", &Count)
+			_, _ = fmt.Sscanf(line, "Klen = %d", &Klen)
+			_, _ = fmt.Sscanf(line, "Mlen = %d", &Mlen)
+			_, _ = fmt.Sscanf(line, "Tlen = %d", &Tlen)
+			_, _ = fmt.Sscanf(line, "Key = %x", &Key)
+			_, _ = fmt.Sscanf(line, "Msg = %x", &Msg)
+			n, _ := fmt.Sscanf(line, "Mac = %x", &Mac)
+			if n > 0 { // An empty Msg is written as 00
+				t.Run(fmt.Sprintf("testCMACGen with  %v  line  %d", fileName, lineNumber),
+					testCMACGen(Key, Msg[0:Mlen], Mac[0:Tlen]))
+			}
+		}
+		_ = fileHandle.Close()
+	}
+}
+
+func testCMACGen(key, message, mac []byte) func(*testing.T) {
+	return func(t *testing.T) {
+		cmac, err := aesgcm.NewCMAC(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = cmac.Write(message)
+		actual := cmac.Sum(nil)
+		if !bytes.Equal(mac, actual[0:len(mac)]) {
+			t.Error(fmt.Sprintf("\nExpected %x\nGot      %x\n", mac, actual)) //
+		}
+	}
+}
+
+func Test_cmac_verification(t *testing.T) {
+	aesgcm.WithEachBackend(t, cmacVerificationVectors)
+}
+
+func cmacVerificationVectors(t *testing.T) {
+	var Count, Klen, Mlen, Tlen int // CAVP test fields
+	var Key, Msg, Mac []byte        // CAVP test fields
+	var Result string
+
+	for _, fileName := range testCMACVerFiles {
+		var lineNumber int
+
+		fileHandle, err := os.Open(fileName)
+		if err != nil {
+			t.Error(fmt.Sprintf("Unable to open file: %v", fileName))
+			return
+		}
+
+		fileScanner := bufio.NewScanner(fileHandle)
+		for fileScanner.Scan() {
+			line := fileScanner.Text()
+			lineNumber++
+			// Slightly inefficient but effective
+			_, _ = fmt.Sscanf(line, "Count = %d", &Count)
+			_, _ = fmt.Sscanf(line, "Klen = %d", &Klen)
+			_, _ = fmt.Sscanf(line, "Mlen = %d", &Mlen)
+			_, _ = fmt.Sscanf(line, "Tlen = %d", &Tlen)
+			_, _ = fmt.Sscanf(line, "Key = %x", &Key)
+			_, _ = fmt.Sscanf(line, "Msg = %x", &Msg)
+			_, _ = fmt.Sscanf(line, "Mac = %x", &Mac)
+			n, _ := fmt.Sscanf(line, "Result = %s", &Result)
+			if n > 0 {
+				t.Run(fmt.Sprintf("testCMACVer with  %v  line  %d", fileName, lineNumber),
+					testCMACVer(Key, Msg[0:Mlen], Mac[0:Tlen], Result[0] == 'P'))
+			}
+		}
+		_ = fileHandle.Close()
+	}
+}
+
+func testCMACVer(key, message, mac []byte, pass bool) func(*testing.T) {
+	return func(t *testing.T) {
+		cmac, err := aesgcm.NewCMAC(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = cmac.Write(message)
+		actual := cmac.Sum(nil)
+		if (subtle.ConstantTimeCompare(mac, actual[0:len(mac)]) == 1) != pass {
+			t.Error(fmt.Sprintf("\nExpected pass %v for %x\nGot      %x\n", pass, mac, actual)) //
+		}
+	}
+}
@@ -11,49 +11,117 @@ type blockWord struct {
 }
 
 func (aesgcm *aesgcm) initGcmH(key []byte) *aesgcm { // init via New
-	var hBlock []byte // 16
-	hBlock = aesgcm.encrypt([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
-	aesgcm.h.left = binary.BigEndian.Uint64(hBlock[0:8])
-	aesgcm.h.right = binary.BigEndian.Uint64(hBlock[8:16])
+	var hBlock = aesgcm.encrypt([16]byte{})
+	aesgcm.setH(bytes2bWord(hBlock[:]))
+	zero(hBlock[:])
+	return aesgcm
+}
+
+func (aesgcm *aesgcm) setH(h blockWord) *aesgcm {
+	aesgcm.h = h
 	aesgcm.hr.left = rev64(aesgcm.h.left)
 	aesgcm.hr.right = rev64(aesgcm.h.right)
+	aesgcm.hPowers[0], aesgcm.hPowersR[0] = aesgcm.h, aesgcm.hr
+	for i := 1; i < len(aesgcm.hPowers); i++ {
+		aesgcm.hPowers[i] = aesgcm.gMulGeneric(aesgcm.hPowers[i-1])
+		aesgcm.hPowersR[i] = blockWord{rev64(aesgcm.hPowers[i].left), rev64(aesgcm.hPowers[i].right)}
+	}
 	return aesgcm
 }
 
-func (aesgcm *aesgcm) initGcmY0(lenA, lenC int, iv []byte) { // init via Seal/Open
-	aesgcm.lenAlenC.left = uint64(lenA) * 8
-	aesgcm.lenAlenC.right = uint64(lenC) * 8
-	aesgcm.icb.left = binary.BigEndian.Uint64(iv[0:8])
-	aesgcm.icb.right = (uint64(binary.BigEndian.Uint32(iv[8:12])) << 32) | 0x01
-	aesgcm.eky0 = bytes2bWord(aesgcm.encrypt(bWord2Bytes(aesgcm.icb)))
+func (aesgcm *aesgcm) initGcmY0(iv []byte) (icb, eky0 blockWord) { // init via Seal/Open
+	if len(iv) == 12 {
+		icb.left = binary.BigEndian.Uint64(iv[0:8])
+		icb.right = (uint64(binary.BigEndian.Uint32(iv[8:12])) << 32) | 0x01
+	} else { // J0 = GHASH(IV || 0^(s+64) || [len(IV)]64), per SP 800-38D section 7.1
+		icb = aesgcm.gHash(iv, blockWord{0, 0})
+		icb = aesgcm.gMul(bwXor(icb, blockWord{0, uint64(len(iv)) * 8}))
+	}
+	var block [16]byte
+	putBlockWord(block[:], icb)
+	block = aesgcm.encrypt(block)
+	eky0 = bytes2bWord(block[:])
+	zero(block[:])
+	return icb, eky0
 }
 
-func (aesgcm *aesgcm) cipherBlocks(message, dst []byte) {
-	var Y = make([]byte, 16)
-	var result []byte
-	for index := 0; index < len(message); index = index + 16 {
-		Y = bWord2Bytes(plusM32(aesgcm.icb, uint32(1+index/16)))
-		result = aesgcm.encrypt(Y)
-		for i := 0; i < min(16, len(message)-index); i++ {
+func (aesgcm *aesgcm) cipherBlocks(icb blockWord, message, dst []byte) {
+	// The keystream stays on the stack and is wiped, so no copy outlives the call
+	var Y, result [64]byte
+	for index := 0; index < len(message); index = index + 64 { // Four counter blocks per AES pass
+		var length = min(64, len(message)-index)
+		var blocks = (length + 15) / 16
+		for block := 0; block < blocks; block++ {
+			putBlockWord(Y[block*16:], plusM32(icb, uint32(1+index/16+block)))
+		}
+		aesgcm.encryptBlocks(result[:], Y[:blocks*16])
+		for i := 0; i < length; i++ {
 			dst[i+index] = message[i+index] ^ result[i]
 		}
 	}
+	zero(result[:])
 }
 
 func (aesgcm *aesgcm) gHash(blocks []byte, yIn blockWord) blockWord {
 
-	yOut := yIn
-	for index := 0; index < 16*(len(blocks)/16); index = index + 16 {
-		yOut = aesgcm.gMul(bwXor(yOut, bytes2bWord(blocks[index:index+16])))
-	}
+	yOut := aesgcm.gHashBlocks(blocks[:16*(len(blocks)/16)], yIn)
 	if len(blocks)%16 > 0 {
-		var tempData = make([]byte, 16)
-		copy(tempData, blocks[16*(len(blocks)/16):])
-		yOut = aesgcm.gMul(bwXor(yOut, bytes2bWord(tempData)))
+		var tempData [16]byte
+		copy(tempData[:], blocks[16*(len(blocks)/16):])
+		yOut = aesgcm.gMul(bwXor(yOut, bytes2bWord(tempData[:])))
 	}
 	return yOut
 }
 
+// gHashBlocksGeneric folds whole 16-byte blocks into y. Eight blocks at a time share one reduction, as
+// (y ^ X1)*H^8 ^ X2*H^7 ^ ... ^ X8*H: the unreduced products are summed first, as reduction is linear
+// (aggregated reduction, section 4 of the white paper cited in gMulGeneric).
+func (aesgcm *aesgcm) gHashBlocksGeneric(blocks []byte, y blockWord) blockWord {
+	for ; len(blocks) >= 128; blocks = blocks[128:] {
+		var product gProduct
+		for i := 0; i < 8; i++ {
+			var x = bytes2bWord(blocks[16*i : 16*i+16])
+			if i == 0 {
+				x = bwXor(x, y)
+			}
+			product.add(x, aesgcm.hPowers[7-i], aesgcm.hPowersR[7-i])
+		}
+		y = reduce256(product.merge())
+	}
+	for index := 0; index < len(blocks); index = index + 16 {
+		y = aesgcm.gMulGeneric(bwXor(y, bytes2bWord(blocks[index:index+16])))
+	}
+	return y
+}
+
+// Running GHASH over input arriving in arbitrary pieces, for GMAC and the multi-part contexts
+type gHashState struct {
+	y        blockWord // GHASH of the whole blocks written so far
+	buffer   [16]byte  // Partial block not yet folded into y
+	buffered int
+}
+
+func (state *gHashState) write(aesgcm *aesgcm, p []byte) {
+	if state.buffered > 0 {
+		var taken = copy(state.buffer[state.buffered:], p)
+		state.buffered += taken
+		p = p[taken:]
+		if state.buffered < 16 {
+			return
+		}
+		state.y = aesgcm.gHashBlocks(state.buffer[:], state.y)
+		state.buffered = 0
+	}
+	var full = 16 * (len(p) / 16)
+	state.y = aesgcm.gHashBlocks(p[:full], state.y)
+	state.buffered = copy(state.buffer[:], p[full:])
+}
+
+// sum returns GHASH with the partial block zero padded, leaving the state unchanged
+func (state *gHashState) sum(aesgcm *aesgcm) blockWord {
+	return aesgcm.gHash(state.buffer[:state.buffered], state.y)
+}
+
 func min(x, y int) int {
 	if x > y {
 		return y
@@ -67,11 +135,10 @@ func bwXor(a, b blockWord) blockWord {
 	return a
 }
 
-func bWord2Bytes(x blockWord) []byte {
-	b := make([]byte, 16)
+// putBlockWord writes x big-endian into b, which is usually a stack array, so that nothing is allocated
+func putBlockWord(b []byte, x blockWord) {
 	binary.BigEndian.PutUint64(b[0:8], x.left)
 	binary.BigEndian.PutUint64(b[8:16], x.right)
-	return b
 }
 
 func bytes2bWord(x []byte) blockWord {
@@ -120,39 +187,63 @@ func bMul64(x, y uint64) uint64 {
 	return z0 | z1 | z2 | z3
 }
 
-func (aesgcm *aesgcm) gMul(x blockWord) blockWord {
-	var result blockWord
+func (aesgcm *aesgcm) gMulGeneric(x blockWord) blockWord {
+	var product gProduct
+	product.add(x, aesgcm.h, aesgcm.hr)
+	return reduce256(product.merge())
+}
 
+// gProduct accumulates the 64-bit partial products of clmul(x, h) for one or more pairs. Everything after
+// the multiplications is linear, so a sum of products is merged, bit-reversed and reduced only once.
+type gProduct struct {
+	z0, z1, z2    uint64 // Low-order bits, z1 still without the Karatsuba correction
+	z0r, z1r, z2r uint64 // Bit-reversed high-order bits, ditto
+}
+
+// add accumulates x times h; hr is h with each half bit-reversed
+func (product *gProduct) add(x, h, hr blockWord) {
 	// Algorithm 2 from https://software.intel.com/sites/default/files/managed/72/cc/clmul-wp-rev-2.02-2014-04-20.pdf
 
 	// See https://en.wikipedia.org/wiki/Karatsuba_algorithm  // Note: Thomas' z2/z1 names are swapped
 
 	// This gives us low-order 64 bits
-	z0 := bMul64(x.right, aesgcm.h.right)
-	z2 := bMul64(x.left, aesgcm.h.left)
-	z1 := bMul64(x.left^x.right, aesgcm.h.left^aesgcm.h.right) ^ z2 ^ z0
+	product.z0 ^= bMul64(x.right, h.right)
+	product.z2 ^= bMul64(x.left, h.left)
+	product.z1 ^= bMul64(x.left^x.right, h.left^h.right)
 
 	// Bit-reverse the operands
 	x0r := rev64(x.right)
 	x1r := rev64(x.left)
-	y0r := aesgcm.hr.right
-	y1r := aesgcm.hr.left
+	y0r := hr.right
+	y1r := hr.left
 
 	// This gives us (bit-reversed) high-order bits
-	z0r := bMul64(x0r, y0r)
-	z2r := bMul64(x1r, y1r)
-	z1r := bMul64(x1r^x0r, y1r^y0r) ^ z2r ^ z0r
+	product.z0r ^= bMul64(x0r, y0r)
+	product.z2r ^= bMul64(x1r, y1r)
+	product.z1r ^= bMul64(x1r^x0r, y1r^y0r)
+}
+
+// merge returns the unreduced 256-bit product, least significant word first
+func (product *gProduct) merge() (v0, v1, v2, v3 uint64) {
+	z1 := product.z1 ^ product.z2 ^ product.z0
+	z1r := product.z1r ^ product.z2r ^ product.z0r
 
 	// Un-reverse the high-order bits and fix bit 63 that was created twice
-	z0h := rev64(z0r) >> 1
+	z0h := rev64(product.z0r) >> 1
 	z1h := rev64(z1r) >> 1
-	z2h := rev64(z2r) >> 1
+	z2h := rev64(product.z2r) >> 1
 
 	// Merge sets of 64-bit results into "single" 256-bit result
-	v0 := z0
-	v1 := z0h ^ z1
-	v2 := z2 ^ z1h
-	v3 := z2h
+	v0 = product.z0
+	v1 = z0h ^ z1
+	v2 = product.z2 ^ z1h
+	v3 = z2h
+	return v0, v1, v2, v3
+}
+
+// reduce256 reduces a merged gProduct modulo the GCM polynomial
+func reduce256(v0, v1, v2, v3 uint64) blockWord {
+	var result blockWord
 
 	// Shift left one to fix into high order bit
 	v3 = (v3 << 1) | (v2 >> 63)
@@ -0,0 +1,207 @@
+package aesgcm
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+)
+
+// AES-GCM-SIV per RFC 8452: the tag is computed over the plaintext with POLYVAL under keys derived from the
+// nonce, then used as the initial counter block, so repeating a nonce only reveals whether two messages with
+// the same additional data are equal.
+type aesgcmsiv struct {
+	keyGenerating aesCipher
+	keySize       int
+}
+
+const maxGCMSIVSize uint64 = 1 << 36 // Plaintext and additional data limit, RFC 8452 section 6
+
+// NewAESGCMSIV returns an AES-GCM-SIV cipher with a 12-byte nonce and 16-byte tag, or ErrKeySize unless the
+// key is 128 or 256 bits. It is slower than NewAESGCM, as every message derives fresh keys, so it is meant
+// for settings where nonces cannot be guaranteed unique.
+func NewAESGCMSIV(key []byte) (cipher.AEAD, error) {
+	if len(key) != 16 && len(key) != 32 {
+		return nil, ErrKeySize
+	}
+	var aesgcmsiv = new(aesgcmsiv)
+	aesgcmsiv.keyGenerating.asm = useAsm
+	aesgcmsiv.keyGenerating.expandAesKey(key)
+	aesgcmsiv.keySize = len(key)
+	return aesgcmsiv, nil
+}
+
+func (aesgcmsiv *aesgcmsiv) NonceSize() int {
+	return defaultNonceSize
+}
+
+func (aesgcmsiv *aesgcmsiv) Overhead() int {
+	return defaultTagSize
+}
+
+// Destroy wipes the key-generating key, see Destroy of NewAESGCM. The per-message keys never outlive
+// their Seal or Open call.
+func (aesgcmsiv *aesgcmsiv) Destroy() {
+	aesgcmsiv.keyGenerating.destroy()
+}
+
+// Seal encrypts and authenticates plaintext, authenticates additionalData and appends the result to dst.
+// It panics with ErrNonceSize, ErrMessageTooLarge or ErrDestroyed, as Seal of NewAESGCM.
+func (aesgcmsiv *aesgcmsiv) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if aesgcmsiv.keyGenerating.destroyed {
+		panic(ErrDestroyed)
+	}
+	if len(nonce) != defaultNonceSize {
+		panic(ErrNonceSize)
+	}
+	if uint64(len(plaintext)) > maxGCMSIVSize || uint64(len(additionalData)) > maxGCMSIVSize {
+		panic(ErrMessageTooLarge)
+	}
+	ret, out := sliceForAppend(dst, len(plaintext)+defaultTagSize)
+	if inexactOverlap(out, plaintext) {
+		panic("Invalid buffer overlap of dst and plaintext")
+	}
+	var derived aesgcm // On the stack, and destroyed once used
+	aesgcmsiv.deriveKeys(&derived, nonce)
+	var tag = derived.sivTag(nonce, plaintext, additionalData)
+	derived.sivCtr(tag, plaintext, out)
+	derived.Destroy()
+	copy(out[len(plaintext):], tag[:])
+	return ret
+}
+
+// Open authenticates and decrypts ciphertext, authenticates additionalData and appends the plaintext to
+// dst. It returns ErrNonceSize, ErrMessageTooLarge or ErrAuthFailed, having wiped any decrypted output, or
+// ErrDestroyed.
+func (aesgcmsiv *aesgcmsiv) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if aesgcmsiv.keyGenerating.destroyed {
+		return nil, ErrDestroyed
+	}
+	if len(nonce) != defaultNonceSize {
+		return nil, ErrNonceSize
+	}
+	if len(ciphertext) < defaultTagSize {
+		return nil, ErrAuthFailed
+	}
+	if uint64(len(ciphertext)-defaultTagSize) > maxGCMSIVSize || uint64(len(additionalData)) > maxGCMSIVSize {
+		return nil, ErrMessageTooLarge
+	}
+	ret, out := sliceForAppend(dst, len(ciphertext)-defaultTagSize)
+	if inexactOverlap(out, ciphertext) {
+		panic("Invalid buffer overlap of dst and ciphertext")
+	}
+	var tag [16]byte
+	copy(tag[:], ciphertext[len(ciphertext)-defaultTagSize:])
+	var derived aesgcm
+	aesgcmsiv.deriveKeys(&derived, nonce)
+	derived.sivCtr(tag, ciphertext[:len(out)], out) // The tag is the IV, so decryption comes first
+	var expectedTag = derived.sivTag(nonce, out, additionalData)
+	derived.Destroy()
+	if subtle.ConstantTimeCompare(tag[:], expectedTag[:]) != 1 {
+		zero(out)
+		return nil, ErrAuthFailed
+	}
+	return ret, nil
+}
+
+// deriveKeys sets derived to the per-nonce encryption key and, as its GHASH key, the POLYVAL authentication
+// key (RFC 8452 section 4). The first 8 bytes of AES(K, LE32(i) || nonce) give 64 bits of key each.
+func (aesgcmsiv *aesgcmsiv) deriveKeys(derived *aesgcm, nonce []byte) {
+	var blocks = 2 + aesgcmsiv.keySize/8
+	var src, keys [6 * 16]byte
+	for i := 0; i < blocks; i++ {
+		binary.LittleEndian.PutUint32(src[16*i:], uint32(i))
+		copy(src[16*i+4:16*i+16], nonce)
+	}
+	for i := 0; i < blocks; i += 4 {
+		var end = 16 * min(blocks, i+4)
+		aesgcmsiv.keyGenerating.encryptBlocks(keys[16*i:end], src[16*i:end])
+	}
+	var authKey, encKey [32]byte
+	copy(authKey[0:8], keys[0:8])
+	copy(authKey[8:16], keys[16:24])
+	for i := 2; i < blocks; i++ {
+		copy(encKey[8*(i-2):8*(i-1)], keys[16*i:16*i+8])
+	}
+
+	derived.asm = aesgcmsiv.keyGenerating.asm
+	derived.expandAesKey(encKey[:aesgcmsiv.keySize])
+	reverseBlock(authKey[:16])
+	derived.setH(mulX(bytes2bWord(authKey[:16])))
+	zero(keys[:])
+	zero(authKey[:])
+	zero(encKey[:])
+}
+
+// sivTag computes POLYVAL(A || P || lengths) xor nonce with the top bit cleared, encrypted (RFC 8452 section 4)
+func (aesgcm *aesgcm) sivTag(nonce, plaintext, additionalData []byte) [16]byte {
+	var s = aesgcm.polyval(additionalData, blockWord{0, 0})
+	s = aesgcm.polyval(plaintext, s)
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[0:8], uint64(len(additionalData))*8)
+	binary.LittleEndian.PutUint64(lengths[8:16], uint64(len(plaintext))*8)
+	s = aesgcm.polyval(lengths[:], s)
+
+	var tag [16]byte
+	putBlockWord(tag[:], s)
+	reverseBlock(tag[:])
+	for i := range nonce {
+		tag[i] ^= nonce[i]
+	}
+	tag[15] &= 0x7f
+	aesgcm.encryptBlocks(tag[:], tag[:])
+	return tag
+}
+
+// polyval continues POLYVAL over data, zero padding the last block. POLYVAL(H, X) is
+// ByteReverse(GHASH(mulX_GHASH(ByteReverse(H)), ByteReverse(X))) (RFC 8452 Appendix A), so the running
+// value stays in the GHASH domain and blocks are reversed into a buffer as they are hashed.
+func (aesgcm *aesgcm) polyval(data []byte, y blockWord) blockWord {
+	var buffer [256]byte
+	for len(data) > 0 {
+		var n = copy(buffer[:], data)
+		data = data[n:]
+		var padded = 16 * ((n + 15) / 16)
+		zero(buffer[n:padded])
+		for block := 0; block < padded; block += 16 {
+			reverseBlock(buffer[block : block+16])
+		}
+		y = aesgcm.gHashBlocks(buffer[:padded], y)
+	}
+	return y
+}
+
+// sivCtr is CTR mode from the tag with its top bit set, incrementing the first 32 bits little-endian and
+// wrapping without carry (RFC 8452 section 4)
+func (aesgcm *aesgcm) sivCtr(tag [16]byte, message, dst []byte) {
+	var counter = tag
+	counter[15] |= 0x80
+	var initial = binary.LittleEndian.Uint32(counter[0:4])
+	var blocks, keyStream [64]byte
+	for index := 0; index < len(message); index = index + 64 { // Four counter blocks per AES pass
+		var length = min(64, len(message)-index)
+		var count = (length + 15) / 16
+		for block := 0; block < count; block++ {
+			copy(blocks[block*16:], counter[:])
+			binary.LittleEndian.PutUint32(blocks[block*16:], initial+uint32(index/16+block))
+		}
+		aesgcm.encryptBlocks(keyStream[:count*16], blocks[:count*16])
+		for i := 0; i < length; i++ {
+			dst[i+index] = message[i+index] ^ keyStream[i]
+		}
+	}
+	zero(keyStream[:])
+}
+
+// mulX multiplies by x in the bit-reflected GHASH field, i.e. a right shift with reduction by 0xe1
+func mulX(x blockWord) blockWord {
+	var mask = -(x.right & 1) // Constant time, as the authentication key is secret
+	x.right = (x.right >> 1) | (x.left << 63)
+	x.left = (x.left >> 1) ^ (mask & (0xe1 << 56))
+	return x
+}
+
+func reverseBlock(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
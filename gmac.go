@@ -0,0 +1,77 @@
+package aesgcm
+
+import (
+	"hash"
+)
+
+// GMAC is GCM with an empty plaintext (NIST SP 800-38D section 3), authenticating its input as the AAD
+type gmac struct {
+	aesgcm *aesgcm
+	eky0   blockWord
+	gHash  gHashState
+	length uint64 // Bytes written
+}
+
+// NewGMAC returns a hash.Hash computing the 16-byte AES-GMAC tag of everything written to it under the given
+// key and nonce, so that large inputs can be authenticated incrementally. The tag equals that of Seal with a
+// nil plaintext and the input as additionalData. As with Seal, a nonce must never be reused with the same key,
+// and only one tag may ever be released per key and nonce: two tags over different messages under one nonce
+// reveal H, which forges tags for every nonce under the key.
+func NewGMAC(key, nonce []byte) (hash.Hash, error) {
+	aead, err := newAESGCM(key, len(nonce), defaultTagSize)
+	if err != nil {
+		return nil, err
+	}
+	var gmac = new(gmac)
+	gmac.aesgcm = aead.(*aesgcm)
+	_, gmac.eky0 = gmac.aesgcm.initGcmY0(nonce)
+	return gmac, nil
+}
+
+// Destroy wipes the key, H and the hash state; the returned hash.Hash implements Destroyer. Later writes
+// return ErrDestroyed and Sum panics with it.
+func (gmac *gmac) Destroy() {
+	gmac.aesgcm.Destroy()
+	gmac.eky0 = blockWord{}
+	gmac.gHash = gHashState{}
+}
+
+// Write returns ErrMessageTooLarge, and writes nothing, once the input would exceed 2^61-1 bytes
+func (gmac *gmac) Write(p []byte) (int, error) {
+	if gmac.aesgcm.destroyed {
+		return 0, ErrDestroyed
+	}
+	if gmac.length+lengthOf(p) > maxAdditionalDataSize || gmac.length+lengthOf(p) < gmac.length {
+		return 0, ErrMessageTooLarge
+	}
+	gmac.length += uint64(len(p))
+	gmac.gHash.write(gmac.aesgcm, p)
+	return len(p), nil
+}
+
+// Sum appends the tag to b without changing the state. Only the tag of the complete message may be released;
+// a tag of a prefix followed by more input is a second tag under the same nonce, see NewGMAC.
+func (gmac *gmac) Sum(b []byte) []byte {
+	if gmac.aesgcm.destroyed {
+		panic(ErrDestroyed)
+	}
+	var tag = gmac.aesgcm.gMul(bwXor(gmac.gHash.sum(gmac.aesgcm), blockWord{gmac.length * 8, 0}))
+	var sum [16]byte
+	putBlockWord(sum[:], bwXor(tag, gmac.eky0))
+	return append(b, sum[:]...)
+}
+
+// Reset clears the input but keeps the key and nonce, so it is only for recomputing the tag of the same
+// message, e.g. to verify it. Authenticate a different message with NewGMAC and a fresh nonce.
+func (gmac *gmac) Reset() {
+	gmac.gHash = gHashState{}
+	gmac.length = 0
+}
+
+func (gmac *gmac) Size() int {
+	return defaultTagSize
+}
+
+func (gmac *gmac) BlockSize() int {
+	return 16
+}
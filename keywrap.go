@@ -0,0 +1,174 @@
+package aesgcm
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// AES Key Wrap (KW, RFC 3394) and Key Wrap with Padding (KWP, RFC 5649), both per NIST SP 800-38F
+// section 6. The wrapping function W runs six passes over the 64-bit semiblocks, each block encryption
+// chaining the integrity value A through every semiblock in turn.
+
+var (
+	kwIV  = [8]byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6} // ICV1, SP 800-38F section 6.2
+	kwpIV = [4]byte{0xa6, 0x59, 0x59, 0xa6}                         // ICV2, SP 800-38F section 6.3
+)
+
+const maxKeyWrapSize uint64 = 1<<32 - 1 // Bytes; KWP records the length in 32 bits
+
+// ErrWrapSize is returned for key wrap input of a length the mode does not accept
+var ErrWrapSize = errors.New("aesgcm: invalid key wrap input length")
+
+// Wrap wraps plaintext, a multiple of 8 bytes and at least 16, under kek per RFC 3394. It returns
+// ErrKeySize or ErrWrapSize.
+func Wrap(kek, plaintext []byte) ([]byte, error) {
+	if len(plaintext) < 16 || len(plaintext)%8 != 0 || uint64(len(plaintext)) > maxKeyWrapSize {
+		return nil, ErrWrapSize
+	}
+	aes, err := newKeyWrapCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	defer aes.destroy()
+	var out = make([]byte, 8+len(plaintext))
+	copy(out, kwIV[:])
+	copy(out[8:], plaintext)
+	aes.wrap(out)
+	return out, nil
+}
+
+// Unwrap unwraps ciphertext wrapped by Wrap under kek, returning ErrAuthFailed if its integrity check fails,
+// or ErrKeySize or ErrWrapSize
+func Unwrap(kek, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 24 || len(ciphertext)%8 != 0 || uint64(len(ciphertext)) > maxKeyWrapSize+8 {
+		return nil, ErrWrapSize
+	}
+	aes, err := newKeyWrapCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	defer aes.destroy()
+	var out = make([]byte, len(ciphertext))
+	copy(out, ciphertext)
+	aes.unwrap(out)
+	if subtle.ConstantTimeCompare(out[:8], kwIV[:]) != 1 {
+		zero(out)
+		return nil, ErrAuthFailed
+	}
+	return out[8:], nil
+}
+
+// WrapPad wraps plaintext of 1 to 2^32 - 1 bytes under kek per RFC 5649, zero padding it to a multiple of 8
+// bytes. It returns ErrKeySize or ErrWrapSize.
+func WrapPad(kek, plaintext []byte) ([]byte, error) {
+	if len(plaintext) < 1 || uint64(len(plaintext)) > maxKeyWrapSize {
+		return nil, ErrWrapSize
+	}
+	aes, err := newKeyWrapCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	defer aes.destroy()
+	var padded = 8 * ((len(plaintext) + 7) / 8)
+	var out = make([]byte, 8+padded)
+	copy(out, kwpIV[:])
+	binary.BigEndian.PutUint32(out[4:8], uint32(len(plaintext)))
+	copy(out[8:], plaintext)
+	if padded == 8 { // A single semiblock is one block encryption, RFC 5649 section 4.1
+		aes.encryptBlocks(out, out)
+	} else {
+		aes.wrap(out)
+	}
+	return out, nil
+}
+
+// UnwrapPad unwraps ciphertext wrapped by WrapPad under kek, returning ErrAuthFailed if its integrity
+// check, length or padding is wrong, or ErrKeySize or ErrWrapSize
+func UnwrapPad(kek, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 16 || len(ciphertext)%8 != 0 || uint64(len(ciphertext)) > maxKeyWrapSize+15 {
+		return nil, ErrWrapSize
+	}
+	aes, err := newKeyWrapCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	defer aes.destroy()
+	var out = make([]byte, len(ciphertext))
+	copy(out, ciphertext)
+	if len(out) == 16 {
+		aes.decryptBlocks(out, out)
+	} else {
+		aes.unwrap(out)
+	}
+
+	// Check the constant, the length range and the zero padding without branching on any one of them
+	var padded = int64(len(out) - 8)
+	var length = int64(binary.BigEndian.Uint32(out[4:8]))
+	var ok = subtle.ConstantTimeCompare(out[:4], kwpIV[:])
+	ok &= lessOrEq(padded-7, length)
+	ok &= lessOrEq(length, padded)
+	var nonZero byte
+	for i := 0; i < 7; i++ {
+		var inPadding = lessOrEq(length, int64(i)+padded-7) // Byte padded-7+i of the key data
+		nonZero |= out[8+padded-7+int64(i)] & byte(-inPadding)
+	}
+	ok &= subtle.ConstantTimeByteEq(nonZero, 0)
+	if ok != 1 {
+		zero(out)
+		return nil, ErrAuthFailed
+	}
+	return out[8 : 8+length], nil
+}
+
+// lessOrEq returns 1 if x <= y and 0 otherwise in constant time, for values well within 63 bits, where
+// subtle.ConstantTimeLessOrEq stops at 2^31 - 1
+func lessOrEq(x, y int64) int {
+	return int(1 ^ (uint64(y-x) >> 63))
+}
+
+// newKeyWrapCipher expands kek for a single wrap or unwrap; callers destroy it once done, so the KEK
+// schedule does not linger on the heap
+func newKeyWrapCipher(kek []byte) (*aesCipher, error) {
+	if (len(kek) != 16) && (len(kek) != 24) && (len(kek) != 32) {
+		return nil, ErrKeySize
+	}
+	var aes = new(aesCipher)
+	aes.asm = useAsm
+	aes.expandAesKey(kek)
+	return aes, nil
+}
+
+// wrap applies W to A || R[1..n] in place, SP 800-38F Algorithm 1
+func (aes *aesCipher) wrap(semiblocks []byte) {
+	var n = len(semiblocks)/8 - 1
+	var block [16]byte
+	copy(block[:8], semiblocks[:8])
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(block[8:], semiblocks[8*i:8*i+8])
+			aes.encryptBlocks(block[:], block[:])
+			binary.BigEndian.PutUint64(block[:8], binary.BigEndian.Uint64(block[:8])^uint64(n*j+i))
+			copy(semiblocks[8*i:8*i+8], block[8:])
+		}
+	}
+	copy(semiblocks[:8], block[:8])
+	zero(block[:])
+}
+
+// unwrap applies W^-1 in place, SP 800-38F Algorithm 2, leaving A for the caller to check
+func (aes *aesCipher) unwrap(semiblocks []byte) {
+	var n = len(semiblocks)/8 - 1
+	var block [16]byte
+	copy(block[:8], semiblocks[:8])
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			binary.BigEndian.PutUint64(block[:8], binary.BigEndian.Uint64(block[:8])^uint64(n*j+i))
+			copy(block[8:], semiblocks[8*i:8*i+8])
+			aes.decryptBlocks(block[:], block[:])
+			copy(semiblocks[8*i:8*i+8], block[8:])
+		}
+	}
+	copy(semiblocks[:8], block[:8])
+	zero(block[:])
+}
@@ -0,0 +1,124 @@
+package aesgcm_test
+
+import (
+	"aesgcm"
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+var testKWEncryptFiles = []string{
+	"./kwtestvectors/KW_AE_128.txt", "./kwtestvectors/KW_AE_192.txt", "./kwtestvectors/KW_AE_256.txt",
+	"./kwtestvectors/KWP_AE_128.txt", "./kwtestvectors/KWP_AE_192.txt", "./kwtestvectors/KWP_AE_256.txt",
+}
+
+var testKWDecryptFiles = []string{
+	"./kwtestvectors/KW_AD_128.txt", "./kwtestvectors/KW_AD_192.txt", "./kwtestvectors/KW_AD_256.txt",
+	"./kwtestvectors/KWP_AD_128.txt", "./kwtestvectors/KWP_AD_192.txt", "./kwtestvectors/KWP_AD_256.txt",
+}
+
+func Test_keyWrap_encryption(t *testing.T) {
+	aesgcm.WithEachBackend(t, keyWrapEncryptionVectors)
+}
+
+func keyWrapEncryptionVectors(t *testing.T) {
+	var Count int      // CAVP test fields
+	var K, P, C []byte // CAVP test fields
+
+	for _, fileName := range testKWEncryptFiles {
+		var lineNumber int
+
+		fileHandle, err := os.Open(fileName)
+		if err != nil {
+			t.Error(fmt.Sprintf("Unable to open file: %v", fileName))
+			return
+		}
+
+		fileScanner := bufio.NewScanner(fileHandle)
+		for fileScanner.Scan() {
+			line := fileScanner.Text()
+			lineNumber++
+			// Slightly inefficient but effective
+			_, _ = fmt.Sscanf(line, "COUNT = %d", &Count)
+			_, _ = fmt.Sscanf(line, "K = %x", &K)
+			_, _ = fmt.Sscanf(line, "P = %x", &P)
+			n, _ := fmt.Sscanf(line, "C = %x", &C)
+			if n > 0 {
+				t.Run(fmt.Sprintf("testWrap with  %v  line  %d", fileName, lineNumber),
+					testWrap(K, P, C, strings.Contains(fileName, "KWP_")))
+			}
+		}
+		_ = fileHandle.Close()
+	}
+}
+
+func testWrap(kek, key, wrapped []byte, padded bool) func(*testing.T) {
+	return func(t *testing.T) {
+		var actual []byte
+		var err error
+		if padded {
+			actual, err = aesgcm.WrapPad(kek, key)
+		} else {
+			actual, err = aesgcm.Wrap(kek, key)
+		}
+		if err != nil || !bytes.Equal(wrapped, actual) {
+			t.Error(fmt.Sprintf("\nExpected %x\nGot      %x  %v\n", wrapped, actual, err)) //
+		}
+	}
+}
+
+func Test_keyWrap_decryption(t *testing.T) {
+	aesgcm.WithEachBackend(t, keyWrapDecryptionVectors)
+}
+
+func keyWrapDecryptionVectors(t *testing.T) {
+	var Count int      // CAVP test fields
+	var K, P, C []byte // CAVP test fields
+
+	for _, fileName := range testKWDecryptFiles {
+		var lineNumber int
+
+		fileHandle, err := os.Open(fileName)
+		if err != nil {
+			t.Error(fmt.Sprintf("Unable to open file: %v", fileName))
+			return
+		}
+
+		fileScanner := bufio.NewScanner(fileHandle)
+		for fileScanner.Scan() {
+			line := fileScanner.Text()
+			lineNumber++
+			// Slightly inefficient but effective
+			_, _ = fmt.Sscanf(line, "COUNT = %d", &Count)
+			_, _ = fmt.Sscanf(line, "K = %x", &K)
+			_, _ = fmt.Sscanf(line, "C = %x", &C)
+			n, _ := fmt.Sscanf(line, "P = %x", &P)
+			if n > 0 || line == "FAIL" { // Only passing tests list a P
+				t.Run(fmt.Sprintf("testUnwrap with  %v  line  %d", fileName, lineNumber),
+					testUnwrap(K, C, P, strings.Contains(fileName, "KWP_"), n > 0))
+			}
+		}
+		_ = fileHandle.Close()
+	}
+}
+
+func testUnwrap(kek, wrapped, key []byte, padded, pass bool) func(*testing.T) {
+	return func(t *testing.T) {
+		var actual []byte
+		var err error
+		if padded {
+			actual, err = aesgcm.UnwrapPad(kek, wrapped)
+		} else {
+			actual, err = aesgcm.Unwrap(kek, wrapped)
+		}
+		if !pass && err == nil {
+			t.Error("Expected integrity check failure")
+		}
+		if pass && (err != nil || !bytes.Equal(key, actual)) {
+			t.Error(fmt.Sprintf("\nExpected %x\nGot      %x  %v\n", key, actual, err)) //
+		}
+	}
+}
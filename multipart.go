@@ -0,0 +1,199 @@
+package aesgcm
+
+import (
+	"crypto/subtle"
+	"errors"
+)
+
+// ErrInvalidState is returned when a multi-part context is used out of order, e.g. UpdateAAD after Update
+var ErrInvalidState = errors.New("aesgcm: multi-part operation called out of order")
+
+// Per-message state of an incremental Seal/Open; lengths are only needed for the final length block
+type gcmContext struct {
+	aesgcm    *aesgcm
+	icb       blockWord
+	eky0      blockWord
+	gHash     gHashState
+	keyStream [16]byte // Counter block partly consumed by the previous Update
+	used      int      // Bytes of keyStream already consumed, 16 when none is pending
+	blocks    uint32   // Counter blocks generated so far
+	lenA      uint64
+	lenC      uint64
+	aadDone   bool // AAD padded and closed by the first Update
+	finished  bool
+}
+
+// Encrypter is a multi-part GCM encryption of a single message, for plaintexts too large to hold in memory.
+// The ciphertext and tag are byte-identical to Seal of the concatenated inputs.
+type Encrypter struct {
+	gcmContext
+}
+
+// Decrypter is the multi-part counterpart of Open. Plaintext returned by Update is unauthenticated until
+// Verify succeeds and must not be used before then.
+type Decrypter struct {
+	gcmContext
+}
+
+// NewEncrypter returns a multi-part encryption context for one message under key and nonce, with a 16-byte
+// tag. Any nonce length of at least one byte is accepted, as with NewAESGCMWithNonceSize. The same nonce
+// must never be reused with the same key.
+func NewEncrypter(key, nonce []byte) (*Encrypter, error) {
+	var encrypter = new(Encrypter)
+	if err := encrypter.init(key, nonce); err != nil {
+		return nil, err
+	}
+	return encrypter, nil
+}
+
+// NewDecrypter returns a multi-part decryption context for one message under key and nonce, see NewEncrypter
+func NewDecrypter(key, nonce []byte) (*Decrypter, error) {
+	var decrypter = new(Decrypter)
+	if err := decrypter.init(key, nonce); err != nil {
+		return nil, err
+	}
+	return decrypter, nil
+}
+
+func (context *gcmContext) init(key, nonce []byte) error {
+	aead, err := newAESGCM(key, len(nonce), defaultTagSize)
+	if err != nil {
+		return err
+	}
+	context.aesgcm = aead.(*aesgcm)
+	context.icb, context.eky0 = context.aesgcm.initGcmY0(nonce)
+	context.used = 16
+	return nil
+}
+
+// Destroy wipes the key, H and the per-message hash and keystream state. Later calls return ErrDestroyed,
+// or for Final a nil tag.
+func (context *gcmContext) Destroy() {
+	context.aesgcm.Destroy()
+	context.icb = blockWord{}
+	context.eky0 = blockWord{}
+	context.gHash = gHashState{}
+	zero(context.keyStream[:])
+	context.used = 16
+	context.finished = true
+}
+
+// UpdateAAD authenticates more additional data; it returns ErrInvalidState once Update has been called, or
+// ErrMessageTooLarge past 2^61-1 bytes in all
+func (context *gcmContext) UpdateAAD(aad []byte) error {
+	if context.aesgcm.destroyed {
+		return ErrDestroyed
+	}
+	if context.aadDone || context.finished {
+		return ErrInvalidState
+	}
+	if context.lenA+lengthOf(aad) > maxAdditionalDataSize || context.lenA+lengthOf(aad) < context.lenA {
+		return ErrMessageTooLarge
+	}
+	context.lenA += uint64(len(aad))
+	context.gHash.write(context.aesgcm, aad)
+	return nil
+}
+
+// Update encrypts src into dst, which must be at least as long; only exact overlap is permitted. It returns
+// ErrMessageTooLarge past 2^36-32 bytes in all, before the 32-bit block counter would wrap.
+func (encrypter *Encrypter) Update(dst, src []byte) error {
+	if err := encrypter.begin(dst, src); err != nil {
+		return err
+	}
+	encrypter.xorKeyStream(dst[:len(src)], src)
+	encrypter.gHash.write(encrypter.aesgcm, dst[:len(src)])
+	return nil
+}
+
+// Update decrypts src into dst, which must be at least as long; only exact overlap is permitted
+func (decrypter *Decrypter) Update(dst, src []byte) error {
+	if err := decrypter.begin(dst, src); err != nil {
+		return err
+	}
+	decrypter.gHash.write(decrypter.aesgcm, src) // Before src may be overwritten in place
+	decrypter.xorKeyStream(dst[:len(src)], src)
+	return nil
+}
+
+// Final returns the 16-byte tag; no further input is accepted, and later calls return the same tag
+func (encrypter *Encrypter) Final() []byte {
+	if encrypter.aesgcm.destroyed {
+		return nil
+	}
+	encrypter.finished = true
+	var tag = encrypter.tag()
+	return append([]byte(nil), tag[:]...)
+}
+
+// Verify checks the 16-byte tag from Final against the ciphertext passed to Update in constant time and
+// returns ErrAuthFailed on mismatch. Any other tag length returns ErrTagSize, so a tag truncated in transit
+// cannot lower the forgery bound.
+func (decrypter *Decrypter) Verify(tag []byte) error {
+	if len(tag) != defaultTagSize {
+		return ErrTagSize
+	}
+	if decrypter.aesgcm.destroyed {
+		return ErrDestroyed
+	}
+	decrypter.finished = true
+	var expectedTag = decrypter.tag()
+	var tagMatch = subtle.ConstantTimeCompare(tag, expectedTag[:len(tag)])
+	zero(expectedTag[:])
+	if tagMatch != 1 {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+func (context *gcmContext) begin(dst, src []byte) error {
+	if context.aesgcm.destroyed {
+		return ErrDestroyed
+	}
+	if context.finished {
+		return ErrInvalidState
+	}
+	if len(dst) < len(src) {
+		panic("aesgcm: output smaller than input")
+	}
+	if inexactOverlap(dst[:len(src)], src) {
+		panic("Invalid buffer overlap of dst and src")
+	}
+	if context.lenC+lengthOf(src) > maxPlaintextSize || context.lenC+lengthOf(src) < context.lenC {
+		return ErrMessageTooLarge
+	}
+	if !context.aadDone { // Pad the AAD to a block boundary before the first ciphertext byte
+		context.gHash.y = context.gHash.sum(context.aesgcm)
+		context.gHash.buffered = 0
+		context.aadDone = true
+	}
+	context.lenC += uint64(len(src))
+	return nil
+}
+
+// xorKeyStream continues the CTR keystream across calls, finishing any partly consumed counter block first
+func (context *gcmContext) xorKeyStream(dst, src []byte) {
+	for len(src) > 0 && context.used < 16 {
+		dst[0] = src[0] ^ context.keyStream[context.used]
+		context.used++
+		dst, src = dst[1:], src[1:]
+	}
+	var full = 16 * (len(src) / 16)
+	context.aesgcm.cipherBlocks(plusM32(context.icb, context.blocks), src[:full], dst[:full])
+	context.blocks += uint32(full / 16)
+	if full < len(src) {
+		var zeros [16]byte
+		context.aesgcm.cipherBlocks(plusM32(context.icb, context.blocks), zeros[:], context.keyStream[:])
+		context.blocks++
+		context.used = 0
+		context.xorKeyStream(dst[full:], src[full:])
+	}
+}
+
+func (context *gcmContext) tag() (tag [16]byte) {
+	var lenAlenC = blockWord{context.lenA * 8, context.lenC * 8}
+	var runningTag = context.gHash.sum(context.aesgcm)
+	runningTag = context.aesgcm.gMul(bwXor(runningTag, lenAlenC))
+	putBlockWord(tag[:], bwXor(runningTag, context.eky0))
+	return tag
+}
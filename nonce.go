@@ -0,0 +1,108 @@
+package aesgcm
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// NonceSequence generates 12-byte nonces by the deterministic construction of NIST SP 800-38D section
+// 8.2.1: a fixed field naming the device or context, followed by an invocation field counting up from
+// zero. Nonces stay unique for one key as long as no two sequences share a fixed field and a sequence is
+// never resumed from an earlier state. It is safe for concurrent use.
+type NonceSequence struct {
+	mutex   sync.Mutex
+	fixed   []byte
+	counter uint64 // Next invocation field value
+	limit   uint64 // Last invocation field value
+	done    bool   // limit has been used
+}
+
+// ErrNonceExhausted is returned once every invocation field value of a NonceSequence has been used
+var ErrNonceExhausted = errors.New("aesgcm: nonce sequence exhausted")
+
+// NewNonceSequence returns a sequence with the given fixed field of 4 to 8 bytes, leaving the other 8 to 4
+// bytes of the nonce for the invocation counter, or ErrNonceSize
+func NewNonceSequence(fixed []byte) (*NonceSequence, error) {
+	if len(fixed) < 4 || len(fixed) > 8 {
+		return nil, ErrNonceSize
+	}
+	var seq = &NonceSequence{fixed: append([]byte(nil), fixed...)}
+	seq.limit = ^uint64(0) >> uint(8*(len(fixed)-4))
+	if seq.limit == ^uint64(0) {
+		seq.limit-- // All ones marks an exhausted state in MarshalBinary
+	}
+	return seq, nil
+}
+
+// Next returns the next nonce, or ErrNonceExhausted
+func (seq *NonceSequence) Next() ([]byte, error) {
+	var nonce = make([]byte, defaultNonceSize)
+	if err := seq.next(nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+func (seq *NonceSequence) next(nonce []byte) error {
+	seq.mutex.Lock()
+	defer seq.mutex.Unlock()
+	if seq.done || seq.fixed == nil { // A zero NonceSequence is only a target for UnmarshalBinary
+		return ErrNonceExhausted
+	}
+	copy(nonce, seq.fixed)
+	var invocation [8]byte
+	binary.BigEndian.PutUint64(invocation[:], seq.counter)
+	copy(nonce[len(seq.fixed):], invocation[len(seq.fixed)-4:])
+	if seq.counter == seq.limit {
+		seq.done = true
+	} else {
+		seq.counter++
+	}
+	return nil
+}
+
+// SealNext seals plaintext with aead under the next nonce and appends the nonce followed by the Seal output
+// to dst. aead must take 12-byte nonces. It returns ErrNonceExhausted rather than reuse a nonce, or
+// ErrNonceSize.
+func (seq *NonceSequence) SealNext(aead cipher.AEAD, dst, plaintext, additionalData []byte) ([]byte, error) {
+	if aead.NonceSize() != defaultNonceSize {
+		return nil, ErrNonceSize
+	}
+	ret, nonce := sliceForAppend(dst, defaultNonceSize)
+	if err := seq.next(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(ret, nonce, plaintext, additionalData), nil
+}
+
+// MarshalBinary returns the fixed field followed by the 8-byte big-endian next invocation value, or all ones
+// once exhausted. To survive restarts, persist the state before releasing anything sealed under the nonces
+// handed out since, or persist a state advanced past a reserved range of nonces.
+func (seq *NonceSequence) MarshalBinary() ([]byte, error) {
+	seq.mutex.Lock()
+	defer seq.mutex.Unlock()
+	var state = make([]byte, len(seq.fixed)+8)
+	copy(state, seq.fixed)
+	var next = seq.counter
+	if seq.done {
+		next = ^uint64(0)
+	}
+	binary.BigEndian.PutUint64(state[len(seq.fixed):], next)
+	return state, nil
+}
+
+// UnmarshalBinary restores a state from MarshalBinary, returning ErrNonceSize for a malformed state
+func (seq *NonceSequence) UnmarshalBinary(state []byte) error {
+	if len(state) < 12 || len(state) > 16 {
+		return ErrNonceSize
+	}
+	restored, _ := NewNonceSequence(state[:len(state)-8])
+	var next = binary.BigEndian.Uint64(state[len(state)-8:])
+	seq.mutex.Lock()
+	defer seq.mutex.Unlock()
+	seq.fixed, seq.limit = restored.fixed, restored.limit
+	seq.counter, seq.done = next, next > seq.limit
+	return nil
+}
@@ -0,0 +1,67 @@
+package aesgcm
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+	"sync/atomic"
+)
+
+// RandomSealer seals with random 96-bit nonces (NIST SP 800-38D section 8.2.2) prepended to the ciphertext,
+// counting invocations so that the instance never seals more than the 2^32 messages section 8.3 allows for
+// random nonces, which keeps the chance of a repeated nonce below 2^-32. The count lives only in the instance
+// and is not persisted, so the limit holds for the key only if exactly one long-lived RandomSealer ever uses
+// it. It is safe for concurrent use.
+type RandomSealer struct {
+	aead        cipher.AEAD
+	invocations uint64 // Updated atomically
+}
+
+const maxRandomInvocations uint64 = 1 << 32
+
+// NewRandomSealer returns a RandomSealer for a 128, 192 or 256-bit key, or ErrKeySize
+func NewRandomSealer(key []byte) (*RandomSealer, error) {
+	aead, err := New(key)
+	if err != nil {
+		return nil, err
+	}
+	return &RandomSealer{aead: aead}, nil
+}
+
+// Overhead returns the length of the nonce and tag added to each plaintext
+func (sealer *RandomSealer) Overhead() int {
+	return defaultNonceSize + defaultTagSize
+}
+
+// Destroy wipes the key, see Destroy of NewAESGCM; SealRandom and OpenRandom then return ErrDestroyed. It
+// must not be called concurrently with them.
+func (sealer *RandomSealer) Destroy() {
+	sealer.aead.(*aesgcm).Destroy()
+}
+
+// SealRandom encrypts and authenticates plaintext under a fresh random nonce, authenticates additionalData
+// and appends the nonce followed by the ciphertext and tag to dst. It returns ErrNonceExhausted once the
+// key has sealed 2^32 messages, when a new key must be used, or an error from crypto/rand.
+func (sealer *RandomSealer) SealRandom(dst, plaintext, additionalData []byte) ([]byte, error) {
+	if sealer.aead.(*aesgcm).destroyed { // Before spending an invocation
+		return nil, ErrDestroyed
+	}
+	if atomic.AddUint64(&sealer.invocations, 1) > maxRandomInvocations {
+		atomic.StoreUint64(&sealer.invocations, maxRandomInvocations+1) // Never wraps back into range
+		return nil, ErrNonceExhausted
+	}
+	ret, nonce := sliceForAppend(dst, defaultNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return sealer.aead.Seal(ret, nonce, plaintext, additionalData), nil
+}
+
+// OpenRandom authenticates and decrypts the output of SealRandom, authenticates additionalData and appends
+// the plaintext to dst. It returns ErrAuthFailed, including for input too short to hold a nonce and tag.
+func (sealer *RandomSealer) OpenRandom(dst, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < defaultNonceSize+defaultTagSize {
+		return nil, ErrAuthFailed
+	}
+	return sealer.aead.Open(dst, sealed[:defaultNonceSize], sealed[defaultNonceSize:], additionalData)
+}
@@ -0,0 +1,178 @@
+package aesgcm
+
+import (
+	"crypto/cipher"
+	"errors"
+	"io"
+	"sync"
+)
+
+// SegmentReader gives random access to the plaintext of a stream written by NewEncryptWriter. Segment i
+// starts at byte i*segmentSize of the plaintext and is sealed under counter i, so an offset maps directly
+// to the segments to fetch and their nonces, and only those segments are read and authenticated.
+type SegmentReader struct {
+	r           io.ReaderAt
+	aead        cipher.AEAD
+	header      [streamHeaderSize]byte
+	segmentSize int64
+	segments    int64 // Including the last, flagged segment
+	size        int64 // Plaintext bytes, authenticated by opening the last segment
+	end         int64 // Stream bytes
+	offset      int64 // Read and Seek position
+
+	buffers sync.Pool // *segmentBuffers for ReadAt, so concurrent calls each get their own
+
+	// Last segment opened by Read, so small sequential reads do not reopen it
+	cached     int64
+	ciphertext []byte
+	plaintext  []byte
+}
+
+// segmentBuffers holds one sealed segment and its plaintext
+type segmentBuffers struct {
+	ciphertext []byte
+	plaintext  []byte
+}
+
+// NewSegmentReader returns a SegmentReader over the size bytes of a stream in r. The segment size and the
+// salt of the per-stream key come from the stream header. The last segment is authenticated here, so a
+// truncated or extended stream returns ErrAuthFailed before any plaintext is released.
+func NewSegmentReader(r io.ReaderAt, size int64, key []byte) (*SegmentReader, error) {
+	if (len(key) != 16) && (len(key) != 24) && (len(key) != 32) {
+		return nil, ErrKeySize
+	}
+	var reader = &SegmentReader{r: r, end: size, cached: -1}
+	if size < int64(streamHeaderSize) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if _, err := r.ReadAt(reader.header[:], 0); err != nil {
+		return nil, err
+	}
+	aead, segmentSize, err := newStreamAEAD(key, reader.header[:])
+	if err != nil {
+		return nil, err
+	}
+	reader.aead = aead
+	reader.segmentSize = int64(segmentSize)
+	var sealedSize = reader.segmentSize + int64(defaultTagSize)
+	var body = size - int64(streamHeaderSize)
+	reader.segments = (body + sealedSize - 1) / sealedSize
+	if reader.segments == 0 || reader.segments-1 > int64(^uint32(0)) {
+		return nil, ErrAuthFailed
+	}
+	reader.ciphertext = make([]byte, sealedSize)
+	reader.plaintext = make([]byte, 0, reader.segmentSize)
+	reader.buffers.New = func() interface{} {
+		return &segmentBuffers{make([]byte, sealedSize), make([]byte, 0, reader.segmentSize)}
+	}
+	last, err := reader.openSegment(reader.segments-1, reader.ciphertext, reader.plaintext)
+	if err != nil {
+		return nil, err
+	}
+	reader.size = (reader.segments-1)*reader.segmentSize + int64(len(last))
+	return reader, nil
+}
+
+// Size returns the length of the plaintext
+func (reader *SegmentReader) Size() int64 {
+	return reader.size
+}
+
+// ReadAt reads plaintext at offset off, authenticating only the segments overlapping p. It returns
+// ErrAuthFailed if any of them was modified, and may be called concurrently as each call takes its own
+// buffers from a pool.
+func (reader *SegmentReader) ReadAt(p []byte, off int64) (int, error) {
+	var buffers = reader.buffers.Get().(*segmentBuffers)
+	defer reader.buffers.Put(buffers)
+	return reader.readAt(p, off, buffers.ciphertext, buffers.plaintext, false)
+}
+
+// Read reads plaintext from the current offset, see ReadAt
+func (reader *SegmentReader) Read(p []byte) (int, error) {
+	n, err := reader.readAt(p, reader.offset, reader.ciphertext, reader.plaintext, true)
+	reader.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the offset for the next Read, per io.Seeker
+func (reader *SegmentReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += reader.offset
+	case io.SeekEnd:
+		offset += reader.size
+	default:
+		return reader.offset, errors.New("aesgcm: invalid whence")
+	}
+	if offset < 0 {
+		return reader.offset, errors.New("aesgcm: negative position")
+	}
+	reader.offset = offset
+	return offset, nil
+}
+
+func (reader *SegmentReader) readAt(p []byte, off int64, ciphertext, plaintext []byte, cache bool) (int, error) {
+	if off < 0 {
+		return 0, errors.New("aesgcm: negative offset")
+	}
+	var n int
+	for n < len(p) && off < reader.size {
+		var index = off / reader.segmentSize
+		var opened []byte
+		if cache && index == reader.cached {
+			opened = plaintext[:cap(plaintext)][:reader.segmentLength(index)]
+		} else {
+			var err error
+			if opened, err = reader.openSegment(index, ciphertext, plaintext); err != nil {
+				if cache {
+					reader.cached = -1
+				}
+				return n, err
+			}
+			if cache {
+				reader.cached = index
+			}
+		}
+		var copied = copy(p[n:], opened[off-index*reader.segmentSize:])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// segmentLength returns the plaintext length of segment index, which is only short for the last segment
+func (reader *SegmentReader) segmentLength(index int64) int {
+	if index == reader.segments-1 {
+		return int(reader.size - index*reader.segmentSize)
+	}
+	return int(reader.segmentSize)
+}
+
+// openSegment reads and authenticates segment index into plaintext under its counter and last flag
+func (reader *SegmentReader) openSegment(index int64, ciphertext, plaintext []byte) ([]byte, error) {
+	var sealedSize = reader.segmentSize + int64(defaultTagSize)
+	var start = int64(streamHeaderSize) + index*sealedSize
+	var length = min64(sealedSize, reader.end-start)
+	n, err := reader.r.ReadAt(ciphertext[:length], start)
+	if err != nil && !(err == io.EOF && int64(n) == length) {
+		return nil, err
+	}
+	var nonce [defaultNonceSize]byte
+	copy(nonce[:], reader.header[streamHeaderSize-streamPrefixSize:])
+	streamNonce(nonce[:], uint32(index), index == reader.segments-1)
+	return reader.aead.Open(plaintext[:0], nonce[:], ciphertext[:length], reader.header[:])
+}
+
+func min64(x, y int64) int64 {
+	if x < y {
+		return x
+	}
+	return y
+}
@@ -0,0 +1,153 @@
+package aesgcm
+
+import (
+	"crypto/subtle"
+	"errors"
+)
+
+// AESSIV is deterministic authenticated encryption per RFC 5297: the synthetic IV is S2V, a CMAC-based PRF
+// over the additional data strings and the plaintext, and is also the tag. Equal inputs give equal
+// ciphertexts and nothing more is revealed, so a random nonce may be passed as the last additional data
+// string where that matters. An AESSIV is safe for concurrent use.
+type AESSIV struct {
+	mac cmac      // K1, the first half of the key
+	ctr aesCipher // K2, the second half
+}
+
+const maxSIVAdditionalData int = 126 // S2V takes at most 127 strings including the plaintext, RFC 5297 section 7
+
+// ErrSIVAdditionalData is returned, or carried by the Seal panic, for more than 126 additional data strings
+var ErrSIVAdditionalData = errors.New("aesgcm: AES-SIV takes at most 126 additional data strings")
+
+// NewAESSIV returns an AES-SIV cipher for a 256, 384 or 512-bit key (AES-128, 192 or 256), or ErrKeySize
+func NewAESSIV(key []byte) (*AESSIV, error) {
+	if (len(key) != 32) && (len(key) != 48) && (len(key) != 64) {
+		return nil, ErrKeySize
+	}
+	mac, _ := NewCMAC(key[:len(key)/2])
+	var aessiv = &AESSIV{mac: *mac.(*cmac)}
+	aessiv.ctr.asm = useAsm
+	aessiv.ctr.expandAesKey(key[len(key)/2:])
+	return aessiv, nil
+}
+
+// Overhead returns the length of the synthetic IV prepended to the ciphertext
+func (aessiv *AESSIV) Overhead() int {
+	return 16
+}
+
+// Destroy wipes both halves of the key, see Destroy of NewAESGCM
+func (aessiv *AESSIV) Destroy() {
+	aessiv.mac.Destroy()
+	aessiv.ctr.destroy()
+}
+
+// Seal encrypts and authenticates plaintext, authenticates each of additionalData and appends the synthetic
+// IV and ciphertext to dst. It panics with ErrSIVAdditionalData on more than 126 additional data strings, or
+// with ErrDestroyed.
+func (aessiv *AESSIV) Seal(dst []byte, additionalData [][]byte, plaintext []byte) []byte {
+	if aessiv.ctr.destroyed {
+		panic(ErrDestroyed)
+	}
+	if len(additionalData) > maxSIVAdditionalData {
+		panic(ErrSIVAdditionalData)
+	}
+	ret, out := sliceForAppend(dst, 16+len(plaintext))
+	if inexactOverlap(out[16:], plaintext) {
+		panic("Invalid buffer overlap of dst and plaintext")
+	}
+	var v = aessiv.s2v(additionalData, plaintext)
+	aessiv.ctrXor(v, plaintext, out[16:])
+	copy(out, v[:])
+	return ret
+}
+
+// Open authenticates and decrypts ciphertext, authenticates each of additionalData and appends the plaintext
+// to dst. It returns ErrAuthFailed, having wiped any decrypted output, ErrSIVAdditionalData or ErrDestroyed.
+func (aessiv *AESSIV) Open(dst []byte, additionalData [][]byte, ciphertext []byte) ([]byte, error) {
+	if aessiv.ctr.destroyed {
+		return nil, ErrDestroyed
+	}
+	if len(additionalData) > maxSIVAdditionalData {
+		return nil, ErrSIVAdditionalData
+	}
+	if len(ciphertext) < 16 {
+		return nil, ErrAuthFailed
+	}
+	ret, out := sliceForAppend(dst, len(ciphertext)-16)
+	if inexactOverlap(out, ciphertext[16:]) {
+		panic("Invalid buffer overlap of dst and ciphertext")
+	}
+	var v [16]byte
+	copy(v[:], ciphertext)
+	aessiv.ctrXor(v, ciphertext[16:], out) // The IV covers the plaintext, so decryption comes first
+	var expected = aessiv.s2v(additionalData, out)
+	if subtle.ConstantTimeCompare(v[:], expected[:]) != 1 {
+		zero(out)
+		return nil, ErrAuthFailed
+	}
+	return ret, nil
+}
+
+// s2v is RFC 5297 section 2.4 with the plaintext as the last string
+func (aessiv *AESSIV) s2v(additionalData [][]byte, plaintext []byte) [16]byte {
+	var mac = aessiv.mac // Copy, so concurrent calls do not share the chaining state
+	var d, sum [16]byte
+	mac.Write(d[:])
+	mac.Sum(sum[:0])
+	d = sum
+	for _, ad := range additionalData {
+		mac.Reset()
+		mac.Write(ad)
+		mac.Sum(sum[:0])
+		d = double(d)
+		for i := range d {
+			d[i] ^= sum[i]
+		}
+	}
+
+	mac.Reset()
+	if len(plaintext) >= 16 { // T = Sn xorend D
+		var last [16]byte
+		copy(last[:], plaintext[len(plaintext)-16:])
+		for i := range last {
+			last[i] ^= d[i]
+		}
+		mac.Write(plaintext[:len(plaintext)-16])
+		mac.Write(last[:])
+	} else { // T = dbl(D) xor pad(Sn)
+		d = double(d)
+		for i := range plaintext {
+			d[i] ^= plaintext[i]
+		}
+		d[len(plaintext)] ^= 0x80
+		mac.Write(d[:])
+	}
+	mac.Sum(sum[:0])
+	mac.Reset()
+	return sum
+}
+
+// ctrXor is CTR mode from the IV with bits 63 and 31 cleared, using a 128-bit big-endian counter
+func (aessiv *AESSIV) ctrXor(v [16]byte, message, dst []byte) {
+	v[8] &= 0x7f
+	v[12] &= 0x7f
+	var counter = bytes2bWord(v[:])
+	var blocks, keyStream [64]byte
+	for index := 0; index < len(message); index = index + 64 { // Four counter blocks per AES pass
+		var length = min(64, len(message)-index)
+		var count = (length + 15) / 16
+		for block := 0; block < count; block++ {
+			putBlockWord(blocks[block*16:], counter)
+			counter.right++
+			if counter.right == 0 {
+				counter.left++
+			}
+		}
+		aessiv.ctr.encryptBlocks(keyStream[:count*16], blocks[:count*16])
+		for i := 0; i < length; i++ {
+			dst[i+index] = message[i+index] ^ keyStream[i]
+		}
+	}
+	zero(keyStream[:])
+}
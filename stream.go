@@ -0,0 +1,245 @@
+package aesgcm
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Segmented streaming per the STREAM construction (Hoang, Reyhanitabar, Rogaway, Vizár, "Online
+// Authenticated-Encryption and its Nonce-Reuse Misuse-Resistance", 2015). The stream is a header of a
+// version byte, the 4-byte big-endian segment size, a random 32-byte salt and a random 7-byte nonce prefix,
+// then each segment sealed with the nonce prefix || 4-byte big-endian segment counter || last-segment flag,
+// and the header as additional data. Reordering or duplicating segments changes their counters and
+// truncating the stream loses the flagged final segment, so all three fail authentication.
+//
+// Segments are sealed under a per-stream key, HKDF-SHA256 (RFC 5869) of the key with the salt, and the
+// version and segment size as info, as in Tink's AES-GCM-HKDF streaming AEAD. A 7-byte prefix alone would be
+// expected to repeat after about 2^28 streams under one key, reusing every segment nonce; with 256-bit salts
+// streams never share a key in practice.
+
+const (
+	defaultSegmentSize int  = 64 * 1024
+	maxSegmentSize     int  = 1 << 24
+	streamVersion      byte = 1
+	streamSaltSize     int  = 32
+	streamPrefixSize   int  = 7
+	streamInfoSize     int  = 1 + 4 // Version and segment size, the HKDF info
+	streamHeaderSize   int  = streamInfoSize + streamSaltSize + streamPrefixSize
+)
+
+// ErrStreamHeader is returned for a stream header with an unknown version or unsupported segment size
+var ErrStreamHeader = errors.New("aesgcm: invalid stream header")
+
+type encryptWriter struct {
+	w         io.Writer
+	aead      cipher.AEAD
+	header    [streamHeaderSize]byte
+	nonce     [defaultNonceSize]byte
+	counter   uint32
+	plaintext []byte // Segment being filled, held back until more data shows it is not the last
+	sealed    []byte
+	err       error // Sticky, including ErrInvalidState after Close
+}
+
+type decryptReader struct {
+	r          io.Reader
+	aead       cipher.AEAD
+	header     [streamHeaderSize]byte
+	nonce      [defaultNonceSize]byte
+	counter    uint32
+	ciphertext []byte // One sealed segment plus one read-ahead byte to detect the last segment
+	pending    int    // Read-ahead bytes at the start of ciphertext
+	opened     []byte
+	plaintext  []byte // Tail of opened not yet returned
+	err        error  // Sticky; io.EOF after the last segment
+}
+
+// NewEncryptWriter returns a writer which encrypts everything written to it as a segmented stream on w, in
+// 64 KiB segments, so there is no overall size limit and the reader releases each segment once verified.
+// Close must be called to seal the final segment; it does not close w.
+func NewEncryptWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	return NewEncryptWriterWithSegmentSize(w, key, defaultSegmentSize)
+}
+
+// NewEncryptWriterWithSegmentSize is NewEncryptWriter with a segment size in bytes of up to 16 MiB, which
+// is recorded in the stream header
+func NewEncryptWriterWithSegmentSize(w io.Writer, key []byte, segmentSize int) (io.WriteCloser, error) {
+	if segmentSize <= 0 || segmentSize > maxSegmentSize {
+		return nil, ErrStreamHeader
+	}
+	var writer = &encryptWriter{w: w}
+	writer.header[0] = streamVersion
+	binary.BigEndian.PutUint32(writer.header[1:streamInfoSize], uint32(segmentSize))
+	if _, err := io.ReadFull(rand.Reader, writer.header[streamInfoSize:]); err != nil {
+		return nil, err
+	}
+	aead, _, err := newStreamAEAD(key, writer.header[:])
+	if err != nil {
+		return nil, err
+	}
+	writer.aead = aead
+	copy(writer.nonce[:], writer.header[streamHeaderSize-streamPrefixSize:])
+	writer.plaintext = make([]byte, 0, segmentSize)
+	writer.sealed = make([]byte, 0, segmentSize+defaultTagSize)
+	if _, err := w.Write(writer.header[:]); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (writer *encryptWriter) Write(p []byte) (int, error) {
+	var written int
+	for writer.err == nil && len(p) > 0 {
+		if len(writer.plaintext) == cap(writer.plaintext) {
+			writer.err = writer.flush(false)
+			continue
+		}
+		var n = copy(writer.plaintext[len(writer.plaintext):cap(writer.plaintext)], p)
+		writer.plaintext = writer.plaintext[:len(writer.plaintext)+n]
+		written += n
+		p = p[n:]
+	}
+	return written, writer.err
+}
+
+// Close seals the final segment, which may be empty, and returns ErrInvalidState if called again
+func (writer *encryptWriter) Close() error {
+	if writer.err != nil {
+		return writer.err
+	}
+	writer.err = writer.flush(true)
+	if writer.err == nil {
+		writer.err = ErrInvalidState
+		return nil
+	}
+	return writer.err
+}
+
+func (writer *encryptWriter) flush(last bool) error {
+	if !last && writer.counter == ^uint32(0) {
+		return ErrMessageTooLarge
+	}
+	streamNonce(writer.nonce[:], writer.counter, last)
+	writer.sealed = writer.aead.Seal(writer.sealed[:0], writer.nonce[:], writer.plaintext, writer.header[:])
+	writer.plaintext = writer.plaintext[:0]
+	writer.counter++
+	_, err := writer.w.Write(writer.sealed)
+	return err
+}
+
+// NewDecryptReader reads the stream header from r and returns a reader of the plaintext of a stream written
+// by NewEncryptWriter. Each segment is returned only once authenticated; a tampered, reordered or truncated
+// stream returns ErrAuthFailed, after the plaintext of the segments before it.
+func NewDecryptReader(r io.Reader, key []byte) (io.Reader, error) {
+	if (len(key) != 16) && (len(key) != 24) && (len(key) != 32) {
+		return nil, ErrKeySize
+	}
+	var reader = &decryptReader{r: r}
+	if _, err := io.ReadFull(r, reader.header[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	aead, segmentSize, err := newStreamAEAD(key, reader.header[:])
+	if err != nil {
+		return nil, err
+	}
+	reader.aead = aead
+	copy(reader.nonce[:], reader.header[streamHeaderSize-streamPrefixSize:])
+	reader.ciphertext = make([]byte, segmentSize+defaultTagSize+1)
+	reader.opened = make([]byte, 0, segmentSize)
+	return reader, nil
+}
+
+func (reader *decryptReader) Read(p []byte) (int, error) {
+	for len(reader.plaintext) == 0 && reader.err == nil {
+		reader.err = reader.next()
+	}
+	if len(reader.plaintext) > 0 {
+		var n = copy(p, reader.plaintext)
+		reader.plaintext = reader.plaintext[n:]
+		return n, nil
+	}
+	return 0, reader.err
+}
+
+// next opens the following segment; a short read means it is the last one
+func (reader *decryptReader) next() error {
+	n, err := io.ReadFull(reader.r, reader.ciphertext[reader.pending:])
+	var length, last = reader.pending + n, false
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		last = true
+	} else if err != nil {
+		return err
+	} else {
+		length--
+	}
+	if !last && reader.counter == ^uint32(0) {
+		return ErrAuthFailed
+	}
+	streamNonce(reader.nonce[:], reader.counter, last)
+	reader.plaintext, err = reader.aead.Open(reader.opened[:0], reader.nonce[:], reader.ciphertext[:length], reader.header[:])
+	if err != nil {
+		return err
+	}
+	reader.counter++
+	if last {
+		return io.EOF // Returned once the plaintext has been drained
+	}
+	reader.pending = 1
+	reader.ciphertext[0] = reader.ciphertext[length]
+	return nil
+}
+
+// newStreamAEAD checks the version and segment size of header and returns the cipher for the per-stream
+// key derived from key and the header salt, or ErrKeySize or ErrStreamHeader
+func newStreamAEAD(key, header []byte) (cipher.AEAD, int, error) {
+	if (len(key) != 16) && (len(key) != 24) && (len(key) != 32) {
+		return nil, 0, ErrKeySize
+	}
+	var segmentSize = int(binary.BigEndian.Uint32(header[1:streamInfoSize]))
+	if header[0] != streamVersion || segmentSize <= 0 || segmentSize > maxSegmentSize {
+		return nil, 0, ErrStreamHeader
+	}
+	var salt = header[streamInfoSize : streamInfoSize+streamSaltSize]
+	var streamKey = hkdfSHA256(key, salt, header[:streamInfoSize], len(key))
+	aead, err := New(streamKey)
+	zero(streamKey)
+	return aead, segmentSize, err
+}
+
+// hkdfSHA256 is HKDF extract-then-expand (RFC 5869 section 2) with HMAC-SHA256, for up to 255*32 bytes
+func hkdfSHA256(secret, salt, info []byte, length int) []byte {
+	var extract = hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	var prk = extract.Sum(nil)
+	var expand = hmac.New(sha256.New, prk)
+	var okm, t []byte
+	for counter := byte(1); len(okm) < length; counter++ { // T(i) = HMAC(PRK, T(i-1) || info || i)
+		expand.Reset()
+		expand.Write(t)
+		expand.Write(info)
+		expand.Write([]byte{counter})
+		t = expand.Sum(t[:0])
+		okm = append(okm, t...)
+	}
+	zero(prk)
+	zero(t)
+	zero(okm[length:])
+	return okm[:length]
+}
+
+// streamNonce sets the counter and last-segment flag after the nonce prefix
+func streamNonce(nonce []byte, counter uint32, last bool) {
+	binary.BigEndian.PutUint32(nonce[streamPrefixSize:], counter)
+	nonce[streamPrefixSize+4] = 0
+	if last {
+		nonce[streamPrefixSize+4] = 1
+	}
+}
@@ -0,0 +1,129 @@
+package aesgcm
+
+import (
+	"encoding/binary"
+)
+
+// XTS-AES per IEEE 1619 and NIST SP 800-38E: each 16-byte block j of a sector is encrypted as
+// AES(K1, P xor T) xor T, where T = AES(K2, sector number) * alpha^j in GF(2^128). A partial final block
+// steals ciphertext from the block before it, so a sector encrypts to the same length.
+type XTS struct {
+	k1 aesCipher // Data key, the first half of the key
+	k2 aesCipher // Tweak key, the second half
+}
+
+// NewXTS returns an XTS-AES cipher for a 256 or 512-bit key (XTS-AES-128 or XTS-AES-256), or ErrKeySize.
+// An XTS is safe for concurrent use.
+func NewXTS(key []byte) (*XTS, error) {
+	if (len(key) != 32) && (len(key) != 64) {
+		return nil, ErrKeySize
+	}
+	var xts = new(XTS)
+	xts.k1.asm = useAsm
+	xts.k1.expandAesKey(key[:len(key)/2])
+	xts.k2.asm = useAsm
+	xts.k2.expandAesKey(key[len(key)/2:])
+	return xts, nil
+}
+
+// Destroy wipes both the data and the tweak key, see Destroy of NewAESGCM. EncryptSector and DecryptSector
+// then panic with ErrDestroyed.
+func (xts *XTS) Destroy() {
+	xts.k1.destroy()
+	xts.k2.destroy()
+}
+
+// EncryptSector encrypts src, one sector of at least 16 bytes, into dst under the given sector number. dst
+// must be at least as long as src, and may only overlap it exactly.
+func (xts *XTS) EncryptSector(dst, src []byte, sectorNum uint64) {
+	xts.crypt(dst, src, sectorTweak(sectorNum), true)
+}
+
+// DecryptSector decrypts src, one sector of at least 16 bytes, into dst, see EncryptSector
+func (xts *XTS) DecryptSector(dst, src []byte, sectorNum uint64) {
+	xts.crypt(dst, src, sectorTweak(sectorNum), false)
+}
+
+// sectorTweak encodes the data unit sequence number as a 128-bit little-endian value (IEEE 1619 section 5.1)
+func sectorTweak(sectorNum uint64) [16]byte {
+	var tweak [16]byte
+	binary.LittleEndian.PutUint64(tweak[:8], sectorNum)
+	return tweak
+}
+
+func (xts *XTS) crypt(dst, src []byte, tweak [16]byte, encrypt bool) {
+	if xts.k1.destroyed {
+		panic(ErrDestroyed)
+	}
+	if len(src) < 16 {
+		panic("aesgcm: XTS sector shorter than one block")
+	}
+	if len(dst) < len(src) {
+		panic("aesgcm: output smaller than input")
+	}
+	if inexactOverlap(dst[:len(src)], src) {
+		panic("Invalid buffer overlap of dst and src")
+	}
+	xts.k2.encryptBlocks(tweak[:], tweak[:])
+
+	var full = 16 * (len(src) / 16)
+	var partial = len(src) - full
+	if partial > 0 {
+		full -= 16 // The last full block takes part in ciphertext stealing
+	}
+	var tweaks, buffer [64]byte
+	for index := 0; index < full; index += 64 { // Four blocks per AES pass
+		var length = min(64, full-index)
+		for block := 0; block < length; block += 16 {
+			copy(tweaks[block:], tweak[:])
+			tweak = mulAlpha(tweak)
+		}
+		xts.xorTweaks(buffer[:length], src[index:index+length], tweaks[:length], encrypt)
+		copy(dst[index:], buffer[:length])
+	}
+
+	if partial > 0 { // IEEE 1619 section 5.3.2; decryption uses the last two tweaks in reverse order
+		var first, second = tweak, mulAlpha(tweak)
+		if !encrypt {
+			first, second = second, first
+		}
+		var last [16]byte
+		xts.xorTweaks(last[:], src[full:full+16], first[:], encrypt)
+		var stolen [16]byte
+		copy(stolen[:], src[full+16:])
+		copy(stolen[partial:], last[partial:])
+		copy(dst[full+16:], last[:partial])
+		xts.xorTweaks(dst[full:full+16], stolen[:], second[:], encrypt)
+		zero(last[:])
+		zero(stolen[:])
+	}
+	zero(buffer[:])
+}
+
+// xorTweaks computes AES(P xor T) xor T, or the inverse, for up to four blocks
+func (xts *XTS) xorTweaks(dst, src, tweaks []byte, encrypt bool) {
+	var block [64]byte
+	for i := range src {
+		block[i] = src[i] ^ tweaks[i]
+	}
+	if encrypt {
+		xts.k1.encryptBlocks(block[:len(src)], block[:len(src)])
+	} else {
+		xts.k1.decryptBlocks(block[:len(src)], block[:len(src)])
+	}
+	for i := range src {
+		dst[i] = block[i] ^ tweaks[i]
+	}
+	zero(block[:])
+}
+
+// mulAlpha multiplies the tweak by the primitive element x of GF(2^128), little-endian, in constant time
+func mulAlpha(tweak [16]byte) [16]byte {
+	var out [16]byte
+	var carry = tweak[15] >> 7
+	for i := 15; i > 0; i-- {
+		out[i] = tweak[i]<<1 | tweak[i-1]>>7
+	}
+	out[0] = tweak[0]<<1 ^ (0x87 & -carry)
+	return out
+}
@@ -0,0 +1,74 @@
+package aesgcm_test
+
+import (
+	"aesgcm"
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+)
+
+var testXTSFiles = []string{
+	"./xtstestvectors/XTSGenAES128.rsp", "./xtstestvectors/XTSGenAES256.rsp",
+}
+
+func Test_xts(t *testing.T) {
+	aesgcm.WithEachBackend(t, xtsVectors)
+}
+
+// Each file has an [ENCRYPT] then a [DECRYPT] section; the record ends with CT or PT respectively
+func xtsVectors(t *testing.T) {
+	var Count, DataUnitLen int // CAVP test fields
+	var Key, I, PT, CT []byte  // CAVP test fields
+	var encrypt bool
+
+	for _, fileName := range testXTSFiles {
+		var lineNumber int
+
+		fileHandle, err := os.Open(fileName)
+		if err != nil {
+			t.Error(fmt.Sprintf("Unable to open file: %v", fileName))
+			return
+		}
+
+		fileScanner := bufio.NewScanner(fileHandle)
+		for fileScanner.Scan() {
+			line := fileScanner.Text()
+			lineNumber++
+			// Slightly inefficient but effective
+			if line == "[ENCRYPT]" || line == "[DECRYPT]" {
+				encrypt = line == "[ENCRYPT]"
+			}
+			_, _ = fmt.Sscanf(line, "COUNT = %d", &Count)
+			_, _ = fmt.Sscanf(line, "DataUnitLen = %d", &DataUnitLen)
+			_, _ = fmt.Sscanf(line, "Key = %x", &Key)
+			_, _ = fmt.Sscanf(line, "i = %x", &I)
+			nPT, _ := fmt.Sscanf(line, "PT = %x", &PT)
+			nCT, _ := fmt.Sscanf(line, "CT = %x", &CT)
+			if ((encrypt && nCT > 0) || (!encrypt && nPT > 0)) && DataUnitLen%8 == 0 { // Bit-granular units are not supported
+				t.Run(fmt.Sprintf("testXTS with  %v  line  %d", fileName, lineNumber),
+					testXTS(Key, I, PT, CT, encrypt))
+			}
+		}
+		_ = fileHandle.Close()
+	}
+}
+
+func testXTS(key, tweak, plainText, cipherText []byte, encrypt bool) func(*testing.T) {
+	return func(t *testing.T) {
+		xts, err := aesgcm.NewXTS(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expected, input = cipherText, plainText
+		if !encrypt {
+			expected, input = plainText, cipherText
+		}
+		actual := make([]byte, len(input))
+		aesgcm.XTSWithTweak(xts, actual, input, tweak, encrypt)
+		if !bytes.Equal(expected, actual) {
+			t.Error(fmt.Sprintf("\nExpected %x\nGot      %x\n", expected, actual)) //
+		}
+	}
+}